@@ -0,0 +1,140 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 网格、订单表和收益统计只在主循环中修改。交易所请求在主循环外执行，
+// 结果以事件的形式发送回主循环处理
+
+var eventChan chan interface{}
+
+// 在主循环外执行请求，退出时等待全部完成，回测时替换为同步执行
+var runAsync = func(fn func()) {
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		fn()
+	}()
+}
+
+// 最新盘口
+type EventTicker struct {
+	Ticker *FuturesItem
+	Err    error
+}
+
+// 定时同步的挂单列表
+type EventOrdersSnapshot struct {
+	Orders   []*Order
+	NotFound []string // 交易所不存在的订单，视为下单失败
+}
+
+// 资金费同步结果
+type EventFunding struct {
+	Payments    []FundingPayment
+	Rate        float64
+	HasRate     bool
+	NextRate    float64
+	HasNextRate bool
+}
+
+var (
+	// 同类请求未返回前不再重复发起
+	tickerPending bool
+	syncPending   bool
+)
+
+// 异步获取盘口
+func requestTicker() {
+	if tickerPending {
+		return
+	}
+	tickerPending = true
+	runAsync(func() {
+		ticker, err := fetchTicker()
+		eventChan <- &EventTicker{Ticker: ticker, Err: err}
+	})
+}
+
+func onTicker(event *EventTicker) {
+	tickerPending = false
+	if event.Err != nil {
+		log.Println("fetchTicker:", event.Err)
+		return
+	}
+	if trailMode == "ema" {
+		updateTrailEma(event.Ticker.Bid, event.Ticker.Ask)
+	}
+	if volMode != "" {
+		recordVolSample(event.Ticker.Bid, event.Ticker.Ask)
+	}
+	if halted || stopping {
+		return
+	}
+	adaptSpacing()
+	updatePriceBand(event.Ticker)
+	runGridCycle(event.Ticker.Bid, event.Ticker.Ask, marketMeta)
+	checkResiduals(marketMeta)
+	writeGridCurrent()
+}
+
+// 需要逐个查询的订单：状态未知或超过 3 秒没有更新的订单，下单结果未返回的订单等待下单结果
+func staleOrders() []string {
+	var stale []string
+	orderMap.RangeOver(func(order *GridOrder) bool {
+		switch {
+		case order.State == OrderPendingNew:
+		case order.State == OrderUnknown || time.Now().Sub(order.UpdateTime) >= time.Second*3:
+			stale = append(stale, order.ClientId)
+		}
+		return true
+	})
+	return stale
+}
+
+// 异步同步挂单状态，未能及时同步的订单逐个查询
+func requestOrderSync(stale []string) {
+	if syncPending {
+		return
+	}
+	syncPending = true
+	runAsync(func() {
+		snapshot := &EventOrdersSnapshot{}
+		defer func() {
+			eventChan <- snapshot
+		}()
+
+		orders, err := fetchOpenOrders(perpName)
+		if err != nil {
+			logrus.WithError(err).Errorln("GetOpenOrders")
+			return
+		}
+		snapshot.Orders = orders
+
+		for _, clientId := range stale {
+			order, err := fetchOrderByClient(clientId)
+			if err != nil {
+				switch err.Error() {
+				case "Order not found":
+					snapshot.NotFound = append(snapshot.NotFound, clientId)
+				}
+				logrus.WithError(err).Errorln("GetOrder", clientId)
+				continue
+			}
+			snapshot.Orders = append(snapshot.Orders, order)
+		}
+	})
+}
+
+func onOrdersSnapshot(event *EventOrdersSnapshot) {
+	syncPending = false
+	for _, order := range event.Orders {
+		onOrderChange(order)
+	}
+	for _, clientId := range event.NotFound {
+		onRejectOrder(clientId, "order not found")
+	}
+}
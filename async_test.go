@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// 模拟盘在独立协程中撮合并推送订单更新，下单工作协程并发发送订单，
+// 主循环按事件处理，配合 go test -race 检查网格和订单表只在主循环中修改
+func TestEventLoopWithPaperSimulator(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	perpName = "TEST-PERP"
+	saveFile = ""
+	orderAuditFile = ""
+	eventChan = make(chan interface{}, 1000)
+	orderMap = NewOrderMap()
+	setMarketMeta(&MarketMeta{PriceIncrement: 0.01, SizeIncrement: 0.001, MinSize: 0.001})
+
+	grids = []*TradeGrid{}
+	for i := 0; i < 10; i++ {
+		grids = append(grids, &TradeGrid{
+			Uuid:        newGridUuid(),
+			OpenAt:      float64(100 - i),
+			CloseAt:     float64(101 - i),
+			OpenChance:  1,
+			OpenOrders:  NewOrderMap(),
+			CloseOrders: NewOrderMap(),
+		})
+	}
+
+	paperBook = NewPaperBook(func(order *Order) {
+		eventChan <- order
+	})
+	paperBook.startDelivery()
+	placer = NewPlacePipeline(4, 50, func(result *EventPlaceResult) {
+		eventChan <- result
+	})
+	defer func() {
+		paperBook, placer = nil, nil
+	}()
+
+	// 盘口随机游走，穿过全部网格
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		random := rand.New(rand.NewSource(1))
+		price := 95.0
+		for i := 0; i < 2000; i++ {
+			price += (random.Float64() - 0.5) * 0.5
+			price = math.Max(89, math.Min(102, price))
+			eventChan <- &EventTicker{Ticker: &FuturesItem{Bid: price, Ask: price + 0.01}}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	syncTicker := time.NewTicker(time.Millisecond * 20)
+	defer syncTicker.Stop()
+	running := true
+	for running {
+		select {
+		case event := <-eventChan:
+			handleEvent(event)
+		case <-syncTicker.C:
+			requestOrderSync(staleOrders())
+		case <-done:
+			running = false
+		}
+	}
+
+	// 等待在途请求和订单推送全部处理完
+	for idle := time.After(time.Millisecond * 200); ; {
+		select {
+		case event := <-eventChan:
+			handleEvent(event)
+			idle = time.After(time.Millisecond * 200)
+			continue
+		case <-idle:
+		}
+		break
+	}
+
+	for _, grid := range grids {
+		if capacity := gridCapacity(grid); math.Abs(capacity-1) > 1e-9 {
+			t.Errorf("grid %v capacity %v, want 1", grid.OpenAt, capacity)
+		}
+	}
+	if position, expected := paperBook.getPosition(), gridExpectedPosition(); math.Abs(position-expected) > 1e-9 {
+		t.Errorf("paper position %v, grid position %v", position, expected)
+	}
+	orderMap.RangeOver(func(order *GridOrder) bool {
+		if order.State == OrderUnknown || order.State == OrderRejected {
+			t.Errorf("order %v in state %v", order.ClientId, order.State)
+		}
+		return true
+	})
+	if reportStats.Fills == 0 {
+		t.Error("no fills simulated")
+	}
+}
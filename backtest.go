@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// 每次请求最多获取的K线数量
+const candlesPerRequest = 1500
+
+func fetchCandles(market string, resolution int, start, end time.Time) ([]*Candle, error) {
+	var all []*Candle
+	step := time.Duration(resolution*candlesPerRequest) * time.Second
+	for from := start; from.Before(end); from = from.Add(step) {
+		to := from.Add(step)
+		if to.After(end) {
+			to = end
+		}
+		candles, err := client.getCandles(market, resolution, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range candles {
+			if len(all) > 0 && !candle.StartTime.After(all[len(all)-1].StartTime) {
+				continue
+			}
+			all = append(all, candle)
+		}
+	}
+	return all, nil
+}
+
+// K线内的价格路径：阳线按 开-低-高-收，阴线按 开-高-低-收
+func candlePath(candle *Candle) []float64 {
+	if candle.Close >= candle.Open {
+		return []float64{candle.Open, candle.Low, candle.High, candle.Close}
+	}
+	return []float64{candle.Open, candle.High, candle.Low, candle.Close}
+}
+
+// backtest 子命令：使用历史K线驱动模拟盘，按实盘相同的网格逻辑下单
+func runBacktest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	from := fs.String("from", "", "开始日期 2006-01-02")
+	to := fs.String("to", "", "结束日期 2006-01-02，默认当前时间")
+	resolution := fs.Int("resolution", 60, "K线周期(秒)")
+	fee := fs.Float64("fee", 0.0002, "挂单费率")
+	fs.Parse(args)
+
+	start, err := time.Parse("2006-01-02", *from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = time.Parse("2006-01-02", *to); err != nil {
+			return fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+
+	loadBaseConfigAndAssign(*cfgFile)
+	makerFee = *fee
+	loadGridConfigAndAssign(*gridFile)
+	if err := checkGridFees(grids); err != nil {
+		return err
+	}
+
+	meta, err := fetchMarketMeta()
+	if err != nil {
+		return err
+	}
+	setMarketMeta(meta)
+	candles, err := fetchCandles(perpName, *resolution, start, end)
+	if err != nil {
+		return err
+	}
+	if len(candles) == 0 {
+		return fmt.Errorf("no candles between %v and %v", start, end)
+	}
+
+	// 回测不保存状态，请求同步执行，订单更新在每个价格点之后处理
+	saveFile = ""
+	orderAuditFile = ""
+	runAsync = func(fn func()) {
+		fn()
+	}
+	var events []*Order
+	paperBook = NewPaperBook(func(order *Order) {
+		events = append(events, order)
+	})
+	var results []*EventPlaceResult
+	placer = NewPlacePipeline(0, 0, func(result *EventPlaceResult) {
+		results = append(results, result)
+	})
+	drain := func() {
+		for len(events) > 0 || len(results) > 0 {
+			pending, pendingResults := events, results
+			events, results = nil, nil
+			for _, order := range pending {
+				onOrderChange(order)
+			}
+			for _, result := range pendingResults {
+				onPlaceResult(result)
+			}
+		}
+	}
+
+	for _, candle := range candles {
+		for _, price := range candlePath(candle) {
+			runGridCycle(price, price+meta.PriceIncrement, meta)
+			drain()
+		}
+	}
+
+	last := candles[len(candles)-1].Close
+	position := paperBook.getPosition()
+	fmt.Println("回测区间：", candles[0].StartTime.Format(time.RFC3339), "~", candles[len(candles)-1].StartTime.Format(time.RFC3339))
+	fmt.Println("K线数量：", len(candles))
+	fmt.Println("成交笔数：", reportStats.Fills)
+	fmt.Println("成交金额：", reportStats.Volume)
+	fmt.Println("网格利润：", profitTotal)
+	fmt.Println("手续费：", feeTotal)
+	fmt.Println("净利润：", netProfitTotal())
+	var unrealized float64
+	for _, grid := range grids {
+		unrealized += grid.position() * (last - grid.OpenAt)
+	}
+	fmt.Println("期末持仓：", position, "网格持仓：", gridExpectedPosition(), "收盘价：", last)
+	fmt.Println("未实现盈亏：", unrealized)
+	debugGrid()
+	return nil
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,107 +16,190 @@ type PriceDiff struct {
 	future    string
 }
 
-func check() bool {
+// 一个周期内计划提交的网格订单
+type PlannedOrder struct {
+	Grid   *TradeGrid
+	Side   string
+	Price  float64
+	Qty    float64
+	Reduce bool
+	Post   bool
+}
+
+// 一个周期的撤单和下单计划
+type OrderPlan struct {
+	Places  []*PlannedOrder
+	Cancels []*GridOrder
+}
+
+// 获取盘口和交易单位，高延迟行情视为失败
+func fetchTicker() (*FuturesItem, error) {
 	since := time.Now()
 
 	perp := &FuturesItem{}
-	resp, err := client.getFuture(perpName)
-	if err != nil {
-		log.Println("getMarkets:", err)
-		return false
-	}
-	if err := parseResult(resp, &perp); err != nil {
-		log.Println("parseResult:", err)
-		return false
+	if isSpot() {
+		spot, err := fetchSpotTicker()
+		if err != nil {
+			return nil, err
+		}
+		perp = spot
+	} else {
+		resp, err := client.getFuture(perpName)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseResult(resp, &perp); err != nil {
+			return nil, err
+		}
 	}
 
-	// 高延迟行情不处理
 	takeTime := time.Now().Sub(since)
 	if takeTime > time.Millisecond*3000 {
-		return false
+		return nil, fmt.Errorf("ticker too slow: %v", takeTime)
 	}
 
-	bid1, ask1 = perp.Bid, perp.Ask
+	return perp, nil
+}
+
+// 根据盘口计算需要撤销和提交的订单，不修改网格状态，价格和数量按市场交易单位取整
+func planOrders(bid, ask float64, meta *MarketMeta) *OrderPlan {
+	plan := &OrderPlan{}
 
-	// 撤掉离盘口太远的订单
+	// 撤掉离盘口太远的订单，买单低于盘口太远，卖单高于盘口太远
 	for _, grid := range grids {
-		// 低于当前盘口太远的买档位撤销
 		for _, order := range grid.OpenOrders.Orders {
-			if grid.OpenAt < bid1 {
-				if grid.OpenAt < bid1*0.92 && time.Now().Sub(order.DeleteAt) > time.Second*20 {
-					client.deleteOrder(order.Id)
-					order.DeleteAt = time.Now()
-				}
+			if farFromBook(order.Side, grid.OpenAt, bid, ask) && !order.pendingCancel() {
+				plan.Cancels = append(plan.Cancels, order)
 			}
 		}
 
-		// 高于当前盘口太远的卖盘不挂
 		for _, order := range grid.CloseOrders.Orders {
-			if grid.CloseAt > ask1 {
-				if grid.CloseAt > ask1*1.08 && time.Now().Sub(order.DeleteAt) > time.Second*20 {
-					client.deleteOrder(order.Id)
-					order.DeleteAt = time.Now()
-				}
+			if farFromBook(order.Side, grid.closePrice(), bid, ask) && !order.pendingCancel() {
+				plan.Cancels = append(plan.Cancels, order)
 			}
 		}
 	}
 
-	changed := false
-	for index, grid := range grids {
-		// 买入仅仅当行情大于格子价格才会形成挂单
+	// 发现手动订单暂停时只撤单不下单
+	if foreignPaused {
+		return plan
+	}
 
-		if grid.OpenChance >= perp.SizeIncrement && grid.OpenAt <= bid1 && grid.OpenAt > (bid1*0.95) {
-			clientId := uuid.New().String()
-			order := &GridOrder{
-				ClientId: clientId,
-				Qty:      grid.OpenChance,
-				CreateAt: time.Now(),
-				Grid:     grid,
-				Side:     "buy",
-			}
-			qty := grid.OpenChance
-			grid.OpenChance -= grid.OpenChance
-			grid.OpenOrders.add(order)
-			persistGrids() // 提前持久话避免崩溃丢失
+	// 现货买单金额不超过计价币种的可用余额
+	quoteAvailable := spotQuoteAvailable()
 
-			place(clientId, perpName, "buy", grid.OpenAt, "limit", qty, false, true)
-			orderMap.add(order)
+	// 只减仓的平仓单合计不能超过实际净持仓，扣除已挂的只减仓单，现货不支持只减仓
+	position := estimatedPosition()
+	reducible := map[string]float64{
+		"sell": position - restingReduceQty("sell"),
+		"buy":  -position - restingReduceQty("buy"),
+	}
+	for _, grid := range grids {
+		// 开仓仅当行情越过格子价格时才会形成挂单
+		paused := fundingPaused
+		if grid.short() {
+			paused = fundingPausedShort
+		}
+		openAt, openQty, ok := meta.roundOrder(grid.openSide(), grid.OpenAt, grid.OpenChance)
+		if ok && !paused && !grid.Retired && !balancePaused(grid.openSide()) &&
+			nearBook(grid.openSide(), openAt, bid, ask) &&
+			(grid.openSide() != "buy" || openAt*openQty <= quoteAvailable) {
+			if grid.openSide() == "buy" {
+				quoteAvailable -= openAt * openQty
+			}
+			plan.Places = append(plan.Places, &PlannedOrder{
+				Grid:  grid,
+				Side:  grid.openSide(),
+				Price: openAt,
+				Qty:   openQty,
+				Post:  true,
+			})
 		}
 
-		if grid.CloseChance >= perp.SizeIncrement && grid.CloseAt >= ask1 && grid.CloseAt < ask1*1.05 {
-			clientId := uuid.New().String()
-			order := &GridOrder{
-				ClientId: clientId,
-				Qty:      grid.CloseChance,
-				CreateAt: time.Now(),
-				Grid:     grid,
-				Side:     "sell",
+		// 平仓单在实际净持仓足够时只减仓，避免多空网格同时持仓时被拒绝
+		closeAt, closeQty, ok := meta.roundOrder(grid.closeSide(), grid.closePrice(), grid.CloseChance)
+		if ok && !balancePaused(grid.closeSide()) && nearBook(grid.closeSide(), closeAt, bid, ask) {
+			reduce := !isSpot() && reducible[grid.closeSide()] >= closeQty-1e-9
+			if reduce {
+				reducible[grid.closeSide()] -= closeQty
 			}
-			grid.CloseOrders.add(order)
-			qty := grid.CloseChance
-			grid.CloseChance -= grid.CloseChance
-			persistGrids() // 提前持久话避免崩溃丢失
+			plan.Places = append(plan.Places, &PlannedOrder{
+				Grid:   grid,
+				Side:   grid.closeSide(),
+				Price:  closeAt,
+				Qty:    closeQty,
+				Reduce: reduce,
+				Post:   true,
+			})
+		}
+	}
+
+	return plan
+}
+
+// 使用最新盘口执行一个周期：撤掉离盘口太远的订单并提交触发的网格订单
+func runGridCycle(bid, ask float64, meta *MarketMeta) bool {
+	bid1, ask1 = bid, ask
+	if paperBook != nil {
+		paperBook.onTicker(bid1, ask1)
+	}
 
-			orderMap.add(order)
+	plan := planOrders(bid1, ask1, meta)
+	for _, order := range plan.Cancels {
+		cancelGridOrder(order)
+	}
 
-			place(clientId, perpName, "sell", grid.CloseAt, "limit", qty, false, false)
+	for _, planned := range plan.Places {
+		grid := planned.Grid
+		clientId := newClientId()
+		order := &GridOrder{
+			ClientId: clientId,
+			Qty:      planned.Qty,
+			CreateAt: time.Now(),
+			Grid:     grid,
+			Side:     planned.Side,
+			State:    OrderPendingNew,
+			Reduce:   planned.Reduce,
+		}
+		auditOrder(order, "", OrderPendingNew, "place")
+		if grid.isOpenSide(planned.Side) {
+			grid.OpenChance -= planned.Qty
+			grid.OpenOrders.add(order)
+		} else {
+			grid.CloseChance -= planned.Qty
+			grid.CloseOrders.add(order)
 		}
+		orderMap.add(order)
+		persistGrids() // 提前持久话避免崩溃丢失
 
-		if changed {
-			log.WithFields(logrus.Fields{
-				"openChance":  grid.OpenChance,
-				"closeChance": grid.CloseChance,
-			}).Infoln("Grid triggered", index)
-			break
+		// 同一网格的订单按顺序发送，队列满时按下单失败处理
+		err := placer.submit(grid.Uuid, &PlaceRequest{
+			ClientId: clientId,
+			Market:   perpName,
+			Side:     planned.Side,
+			Price:    planned.Price,
+			Type:     "limit",
+			Size:     planned.Qty,
+			Reduce:   planned.Reduce,
+			Post:     planned.Post,
+		})
+		if err != nil {
+			log.WithError(err).WithField("clientId", clientId).Errorln("PlaceError")
+			onRejectOrder(clientId, err.Error())
 		}
 	}
 
-	return changed
+	return len(plan.Places) > 0
 }
 
 func onOrderChange(order *Order) {
 	gridOrder, found := orderMap.get(order.ClientID)
 	if !found {
+		if isTakerOrder(order.ClientID) {
+			onTakerOrder(order)
+			return
+		}
+		onForeignOrder(order)
 		return
 	}
 
@@ -124,29 +207,69 @@ func onOrderChange(order *Order) {
 	closed := order.Status == "closed"
 	grid := gridOrder.Grid // 订单归属网格
 
+	// 成交数量比已处理的少，是过期的订单数据
+	if delta < 0 {
+		return
+	}
+
 	if gridOrder.Id == 0 {
 		gridOrder.Id = order.ID
 	}
 	gridOrder.UpdateTime = time.Now()
 
+	// 待撤订单在关闭前保持待撤状态
+	next := exchangeOrderState(order)
+	if gridOrder.State == OrderPendingCancel && !next.terminal() {
+		next = OrderPendingCancel
+	}
+	reason := "exchange " + order.Status
+	// 未请求撤单的 post only 订单未成交就关闭，是下单时会吃单被交易所撤销，下个周期重新挂单
+	if closed && order.FilledSize == 0 && order.PostOnly && gridOrder.State != OrderPendingCancel {
+		reason = "post only rejected"
+		log.WithField("clientId", order.ClientID).WithField("price", order.Price).Infoln("PostOnlyRejected")
+	}
+	gridOrder.transit(next, reason)
+
 	// 订单未处理成交部分
 	if delta > 0.0 {
 		gridOrder.EQty = order.FilledSize
-		if order.Side == "buy" {
-			grid.CloseChance += delta
-			grid.OpenTotal += delta
+		// 网格订单均为 post only，按订单实际的挂单属性选择费率
+		rate := feeRate(order)
+		fee := delta * order.Price * rate
+		grid.FeeTotal += fee
+		feeTotal += fee
+		var profit float64
+		if grid.isOpenSide(order.Side) {
+			// 现货买入的手续费从到账的基础币种中扣除，只平掉实际到账的数量，差额回到开仓机会保持网格额度不变
+			received := delta
+			if isSpot() && paperBook == nil && order.Side == "buy" && rate > 0 {
+				received = delta * (1 - rate)
+			}
+			grid.CloseChance += received
+			grid.OpenTotal += received
+			grid.OpenChance += delta - received
 		} else {
-			grid.OpenChance += delta
+			grid.restoreOpenChance(delta)
 			grid.CloseTotal += delta
 
-			profitTotal += delta * (grid.CloseAt - grid.OpenAt)
+			// 平仓价可能按波动率调整过，按订单价格计算利润
+			profit = delta * grid.sign() * (order.Price - grid.OpenAt)
+			grid.Profit += profit
+			profitTotal += profit
+
+			// 退役网格平仓后，占用的额度还给接替的网格
+			if successor := findGrid(grid.Successor); grid.Retired && successor != nil && !successor.Retired {
+				successor.restoreOpenChance(delta)
+			}
 		}
+		reportStats.addFill(delta, order.Price, fee, profit)
+		recordFill(grid, order.Side, order.Price, delta)
 	}
 
 	// 订单关闭处理未成交部分
 	if closed {
-		if order.Side == "buy" {
-			grid.OpenChance += order.Size - order.FilledSize
+		if grid.isOpenSide(order.Side) {
+			grid.restoreOpenChance(order.Size - order.FilledSize)
 			grid.OpenOrders.remove(order.ClientID)
 		} else {
 			grid.CloseChance += order.Size - order.FilledSize
@@ -158,16 +281,22 @@ func onOrderChange(order *Order) {
 	}
 }
 
-func onRejectOrder(clientId, side string) {
-	logrus.Infoln("RejectOrder", clientId, side)
+func onRejectOrder(clientId, reason string) {
+	logrus.Infoln("RejectOrder", clientId, reason)
 	gridOrder, found := orderMap.get(clientId)
 	if !found {
 		return
 	}
 	grid := gridOrder.Grid // 订单归属网格
 
-	if side == "buy" {
-		grid.OpenChance += gridOrder.Qty
+	// 交易所已经确认的订单不能视为下单失败，等待下次同步确认
+	if !gridOrder.transit(OrderRejected, reason) {
+		gridOrder.transit(OrderUnknown, reason)
+		return
+	}
+
+	if grid.isOpenSide(gridOrder.Side) {
+		grid.restoreOpenChance(gridOrder.Qty)
 		grid.OpenOrders.remove(clientId)
 	} else {
 		grid.CloseChance += gridOrder.Qty
@@ -176,4 +305,76 @@ func onRejectOrder(clientId, side string) {
 	orderMap.remove(clientId)
 }
 
-var RejectOrder func(clientId, side string)
+// 请求撤单，订单进入待撤状态直到收到关闭的订单更新
+func cancelGridOrder(order *GridOrder) {
+	if holdCancels {
+		heldCancels = append(heldCancels, order)
+		return
+	}
+
+	now := time.Now()
+	if order.CancelAt.IsZero() {
+		order.CancelAt = now
+	}
+	order.transit(OrderPendingCancel, "cancel")
+	order.CancelSentAt = now
+	order.CancelTimes++
+
+	clientId, id, times := order.ClientId, order.Id, order.CancelTimes
+	runAsync(func() {
+		sendCancel(clientId, id, times)
+	})
+}
+
+func sendCancel(clientId string, id int64, times int) {
+	var (
+		result string
+		err    error
+	)
+	switch {
+	case paperBook != nil:
+		err = paperBook.cancel(clientId)
+	case id == 0:
+		// 尚未收到订单号时只能通过客户端订单号撤单
+		resp, reqErr := client.deleteOrderByClient(clientId)
+		err = parseResultWrap(reqErr, resp, &result)
+	default:
+		resp, reqErr := client.deleteOrder(id)
+		err = parseResultWrap(reqErr, resp, &result)
+	}
+
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"clientId": clientId,
+			"id":       id,
+			"times":    times,
+		}).Errorln("CancelOrder")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"clientId": clientId,
+		"id":       id,
+		"result":   result,
+	}).Infoln("CancelOrder")
+}
+
+// 检查已请求撤单但仍未关闭的订单，重发撤单并在超时后告警
+func checkPendingCancels() {
+	orderMap.RangeOver(func(order *GridOrder) bool {
+		if !order.pendingCancel() {
+			return true
+		}
+
+		if time.Now().Sub(order.CancelSentAt) > cancelRetryInterval {
+			cancelGridOrder(order)
+		}
+
+		if !order.CancelAlerted && time.Now().Sub(order.CancelAt) > cancelAlertTimeout {
+			order.CancelAlerted = true
+			SendDingTalkAsync(fmt.Sprintln("撤单超时未确认:", order.ClientId, order.Id, order.Side,
+				"请求时间：", order.CancelAt.Format("15:04:05"), "撤单次数：", order.CancelTimes))
+		}
+		return true
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// 所有交互输入共用一个标准输入读取器，避免缓冲的内容被另一个读取器读走
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func prompt(text string) string {
+	fmt.Fprint(os.Stderr, text)
+	line, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// 读取口令和密钥，终端输入时不回显
+func promptSecret(text string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return prompt(text)
+	}
+	fmt.Fprint(os.Stderr, text)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(secret))
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [-cfg config.json] [-grid grid.csv] <command> [args]
+
+Commands:
+  run             执行网格(默认)
+  paper           模拟盘，使用真实行情在本地撮合
+  monitor-margin  仅监控保证金率
+  status          打印保存的网格状态
+  cancel-all      撤销网格市场的全部订单
+  positions       打印当前持仓
+  gen             生成网格文件
+  validate        校验配置和网格文件
+  backtest        使用历史K线回测网格
+  export          导出成交和订单历史
+  keystore        管理加密的 API 密钥
+  halt            紧急停止：撤销全部订单，-flatten 同时平仓，-paper 停止模拟盘
+  resume          清除紧急停止状态，-paper 恢复模拟盘
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func runCommand(command string, args []string) error {
+	switch command {
+	case "run":
+		return runStrategy(false, args)
+	case "paper":
+		return runStrategy(true, args)
+	case "monitor-margin":
+		loadBaseConfigAndAssign(*cfgFile)
+		mfLoop()
+	case "status":
+		return runStatus(args)
+	case "cancel-all":
+		return runCancelAll()
+	case "positions":
+		loadBaseConfigAndAssign(*cfgFile)
+		debugPositions()
+	case "gen":
+		return runGen(args)
+	case "validate":
+		if !runValidate(*cfgFile, *gridFile) {
+			os.Exit(1)
+		}
+	case "backtest":
+		return runBacktest(args)
+	case "export":
+		return runExport(args)
+	case "keystore":
+		return runKeystore(args)
+	case "halt":
+		return runHalt(args)
+	case "resume":
+		return runResume(args)
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return nil
+}
+
+// status 子命令：读取保存的运行状态并打印，不访问交易所
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	paper := fs.Bool("paper", false, "查看模拟盘状态")
+	fs.Parse(args)
+
+	if *paper {
+		usePaperFiles()
+	}
+	if err := loadFromSaveFile(saveFile); err != nil {
+		return err
+	}
+
+	debugGrid()
+
+	var openOrders, closeOrders int
+	states := map[OrderState]int{}
+	orderMap.RangeOver(func(order *GridOrder) bool {
+		if order.Side == "buy" {
+			openOrders++
+		} else {
+			closeOrders++
+		}
+		states[order.State]++
+		return true
+	})
+
+	fmt.Println("网格数量：", len(grids))
+	fmt.Println("挂单：", "买", openOrders, "卖", closeOrders)
+	for _, state := range []OrderState{OrderPendingNew, OrderOpen, OrderPartiallyFilled, OrderPendingCancel, OrderUnknown} {
+		if states[state] > 0 {
+			fmt.Println("  ", state, states[state])
+		}
+	}
+	fmt.Println("网格持仓：", gridExpectedPosition())
+	if foreignPosition != 0 {
+		fmt.Println("手动持仓：", foreignPosition)
+	}
+	fmt.Println("网格利润：", profitTotal)
+	fmt.Println("手续费：", feeTotal)
+	fmt.Println("资金费：", -fundingPaid)
+	fmt.Println("净利润：", netProfitTotal())
+	return nil
+}
+
+// cancel-all 子命令：撤销网格市场的全部订单，网格重启后会通过订单同步恢复额度
+func runCancelAll() error {
+	loadBaseConfigAndAssign(*cfgFile)
+	loadGridConfigAndAssign(*gridFile)
+
+	resp, err := client.deleteAllOrders(perpName)
+	var result string
+	if err := parseResultWrap(err, resp, &result); err != nil {
+		return err
+	}
+	log.WithField("market", perpName).Infoln("CancelAll", result)
+	return nil
+}
+
+// gen 子命令：按固定间隔生成网格文件
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	market := fs.String("market", "", "合约名称")
+	future := fs.String("future", "", "交割合约名称")
+	low := fs.Float64("low", 0, "最低开仓价")
+	high := fs.Float64("high", 0, "最高开仓价")
+	step := fs.Float64("step", 0, "开仓价间隔")
+	spread := fs.Float64("spread", 0, "平仓价与开仓价的距离，默认等于间隔")
+	qty := fs.Float64("qty", 0, "每格数量")
+	direction := fs.String("direction", GridLong, "网格方向：long 做多，short 做空，neutral 中心价及下方做多、上方做空")
+	center := fs.Float64("center", 0, "neutral 网格的中心价，默认为 low 和 high 的中点")
+	output := fs.String("o", "", "输出文件，默认输出到标准输出")
+	fs.Parse(args)
+
+	if *spread == 0 {
+		*spread = *step
+	}
+	if *market == "" || *low <= 0 || *high < *low || *step <= 0 || *qty <= 0 {
+		fs.Usage()
+		return fmt.Errorf("market, low, high, step and qty are required")
+	}
+	if *future == "" {
+		*future = *market
+	}
+	switch *direction {
+	case GridLong, GridShort:
+	case "neutral":
+		if *center == 0 {
+			*center = (*low + *high) / 2
+		}
+	default:
+		return fmt.Errorf("direction must be long, short or neutral, got %q", *direction)
+	}
+
+	content := genGridFile(*market, *future, *low, *high, *step, *spread, *qty, *direction, *center)
+	if *output == "" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(*output, content, 0666)
+}
+
+// 生成网格文件内容，输出 10 列，第 9 列 uuid 用于合并时匹配 save.yaml 中的网格，第 10 列为方向。
+// 档位从 low 开始按间隔的整数倍计算，平仓价与开仓价相差 spread，都不按间隔取整，只去掉浮点误差
+func genGridFile(market, future string, low, high, step, spread, qty float64, direction string, center float64) []byte {
+	precision := finestIncrement(low, step, spread)
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%s,%s,,,,,,,,\n", market, future)
+	fmt.Fprintf(buf, "openPrice,closePrice,openChance,closeChance,qty,closeOnly,openOnly,oneShoot,uuid,direction\n")
+	count := int((high-low)/step + 1e-9)
+	for i := 0; i <= count; i++ {
+		// 按间隔的整数倍计算，避免浮点累加误差
+		level := trimFloat(low+float64(i)*step, precision)
+		// 中性网格中心价所在的档位做多
+		short := direction == GridShort || (direction == "neutral" && level > center)
+		if short {
+			// 做空网格在该价格卖出开仓，低一个价差买回
+			fmt.Fprintf(buf, "%v,%v,%v,0,%v,0,0,0,%s,%s\n", level, trimFloat(level-spread, precision), qty, qty, newGridUuid(), GridShort)
+		} else {
+			fmt.Fprintf(buf, "%v,%v,%v,0,%v,0,0,0,%s,%s\n", level, trimFloat(level+spread, precision), qty, qty, newGridUuid(), GridLong)
+		}
+	}
+	return buf.Bytes()
+}
+
+// 小数位最多的数值，作为生成价格时去掉浮点误差的精度
+func finestIncrement(values ...float64) float64 {
+	var finest float64
+	decimals := -1
+	for _, value := range values {
+		s := strconv.FormatFloat(value, 'f', -1, 64)
+		n := 0
+		if index := strings.Index(s, "."); index >= 0 {
+			n = len(s) - index - 1
+		}
+		if n > decimals {
+			finest, decimals = value, n
+		}
+	}
+	return finest
+}
+
+// export 子命令：导出成交和订单历史到 csv
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := fs.String("dir", ".", "导出目录")
+	fs.Parse(args)
+
+	loadBaseConfigAndAssign(*cfgFile)
+	loadGridConfigAndAssign(*gridFile)
+	return exportHistory(perpName, *dir)
+}
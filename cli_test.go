@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+// gen 生成的档位从 low 开始，平仓价按 spread 偏移，不按 step 取整，生成的文件能通过校验
+func TestGenGridFile(t *testing.T) {
+	cases := []struct {
+		name      string
+		low       float64
+		high      float64
+		step      float64
+		spread    float64
+		direction string
+		center    float64
+		rows      [][2]float64
+	}{
+		{
+			name: "low off the step grid",
+			low:  2.52, high: 2.62, step: 0.05, spread: 0.05,
+			direction: GridLong,
+			rows:      [][2]float64{{2.52, 2.57}, {2.57, 2.62}, {2.62, 2.67}},
+		},
+		{
+			name: "spread smaller than step",
+			low:  2.5, high: 2.6, step: 0.05, spread: 0.02,
+			direction: GridLong,
+			rows:      [][2]float64{{2.5, 2.52}, {2.55, 2.57}, {2.6, 2.62}},
+		},
+		{
+			name: "neutral",
+			low:  2.5, high: 2.6, step: 0.05, spread: 0.02,
+			direction: "neutral", center: 2.55,
+			rows: [][2]float64{{2.5, 2.52}, {2.55, 2.57}, {2.6, 2.58}},
+		},
+	}
+
+	for _, c := range cases {
+		content := genGridFile("UNI-PERP", "UNI-PERP", c.low, c.high, c.step, c.spread, 1, c.direction, c.center)
+		records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		_, _, fileGrids, problems := parseGridRecords("grid.csv", records)
+		if problems.hasError() {
+			t.Fatalf("%s: %v", c.name, problems)
+		}
+		if len(fileGrids) != len(c.rows) {
+			t.Fatalf("%s: got %d grids, want %d", c.name, len(fileGrids), len(c.rows))
+		}
+		for i, grid := range fileGrids {
+			if grid.OpenAt != c.rows[i][0] || grid.CloseAt != c.rows[i][1] {
+				t.Errorf("%s: row %d got %v-%v, want %v-%v", c.name, i, grid.OpenAt, grid.CloseAt, c.rows[i][0], c.rows[i][1])
+			}
+		}
+	}
+}
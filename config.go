@@ -2,13 +2,15 @@ package main
 
 import (
 	"bytes"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -34,25 +36,39 @@ var (
 	quickRecheckInterval = time.Millisecond * 500
 	// 常规价格检查间隔
 	checkInterval = time.Millisecond * 1500
+	// 撤单未确认时的重发间隔
+	cancelRetryInterval = time.Second * 20
+	// 撤单后订单仍未关闭超过该时间将发出告警
+	cancelAlertTimeout = time.Minute * 2
 
 	grids = []*TradeGrid{}
 
+	// 运行状态文件，模拟盘使用独立文件
+	saveFile = "save.yaml"
+
 	orderMap = NewOrderMap()
 
 	client *FtxClient
 
-	lastPlaceTime time.Time
-
 	ask1 float64
 	bid1 float64
 
 	profitTotal float64
+	// 累计手续费，净利润 = profitTotal - feeTotal
+	feeTotal float64
+
+	// 账户费率，启动时从交易所读取
+	makerFee float64
+	takerFee float64
+	// 网格价差不足以覆盖手续费时的处理方式：warn 仅告警，refuse 拒绝启动
+	minSpreadAction = "warn"
 )
 
 type PersistData struct {
 	Grids []*TradeGrid
 }
 
+// 订单表只在主循环中访问，不需要加锁
 type OrderMap struct {
 	Orders map[string]*GridOrder
 }
@@ -64,14 +80,10 @@ func NewOrderMap() *OrderMap {
 }
 
 func (orderm *OrderMap) add(order *GridOrder) {
-	// orderm.mutex.Lock()
-	// defer orderm.mutex.Unlock()
 	orderm.Orders[order.ClientId] = order
 }
 
 func (orderm *OrderMap) RangeOver(fn func(order *GridOrder) bool) {
-	// orderm.mutex.Lock()
-	// defer orderm.mutex.Unlock()
 	for _, order := range orderm.Orders {
 		if !fn(order) {
 			break
@@ -80,14 +92,10 @@ func (orderm *OrderMap) RangeOver(fn func(order *GridOrder) bool) {
 }
 
 func (orderm *OrderMap) remove(clientId string) {
-	// orderm.mutex.Lock()
-	// defer orderm.mutex.Unlock()
 	delete(orderm.Orders, clientId)
 }
 
 func (orderm *OrderMap) get(clientId string) (*GridOrder, bool) {
-	// orderm.mutex.Lock()
-	// defer orderm.mutex.Unlock()
 	order, found := orderm.Orders[clientId]
 	return order, found
 }
@@ -100,66 +108,13 @@ func debugGrid() {
 	for index, grid := range grids {
 		gridQty := float64(grid.CloseChance + grid.OpenChance)
 		totalQty += gridQty
-		log.Printf("[%03d] %v %v %v %v -- gridQty=%v accQty=%v distance=%0.6v", index,
+		log.Printf("[%03d] %v %v %v %v -- gridQty=%v accQty=%v distance=%0.6v netSpread=%0.6v netProfit=%0.6v", index,
 			grid.OpenAt, grid.CloseAt, grid.OpenChance, grid.CloseChance, gridQty, totalQty,
-			grid.OpenAt-grid.CloseAt,
+			grid.OpenAt-grid.CloseAt, grid.netSpread(), grid.netProfit(),
 		)
 	}
 }
 
-func place(clientId string, market string, side string, price float64, _type string, size float64, reduce bool, post bool) {
-	log.Infoln("PlaceOrder", clientId, market, side, price, _type, size, "reduce", reduce, "postonly", post)
-	if *testMode {
-		return
-	}
-
-	lastPlaceTime = time.Now()
-
-	resp, err := client.placeOrder(clientId, market, side, price, _type, size, reduce, post)
-	if err != nil {
-		log.Errorln("PlaceError", err)
-		SendDingTalkAsync(fmt.Sprintln("发送订单失败:", market, side, price, _type, size, reduce, "原因：", err))
-		return
-	}
-	defer resp.Body.Close()
-	b, _ := ioutil.ReadAll(resp.Body)
-
-	var result Result
-	json.Unmarshal(b, &result)
-
-	if result.Error != "" {
-		RejectOrder(clientId, side)
-		SendDingTalkAsync(fmt.Sprintln("发送订单失败:", market, side, price, _type, size, reduce, "原因：", result.Error))
-	}
-
-	log.Infoln("PlaceResult", string(b))
-}
-
-func mustFloat(s string) float64 {
-	f, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		panic("invalid float " + s)
-	}
-	return f
-}
-
-func mustInt(s string) int64 {
-	n, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		panic("invalid int " + s)
-	}
-	return n
-}
-
-func mustBool(s string) bool {
-	n, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		panic("invalid int " + s)
-	}
-
-	return n != 0
-}
-
 func loadConfigAndAssign() {
 	if *gridFile != "" {
 		loadGridConfigAndAssign(*gridFile)
@@ -171,6 +126,11 @@ func loadConfigAndAssign() {
 }
 
 func debugPositions() {
+	if paperBook != nil {
+		log.Infoln("PaperPosition", paperBook.getPosition())
+		return
+	}
+
 	rsp, err := client.getPositions()
 	if err != nil {
 		log.Println("getPositions", err)
@@ -193,36 +153,46 @@ func writeGridCurrent() {
 		fmt.Fprintf(buf, "%v,%v,%v,%v\n", grid.OpenAt, grid.CloseAt, grid.OpenChance, grid.CloseChance)
 	}
 
-	ioutil.WriteFile(perpName+"_grid_runtime.csv", buf.Bytes(), 0666)
+	file := perpName + "_grid_runtime.csv"
+	if paperBook != nil {
+		file = "paper_" + file
+	}
+	ioutil.WriteFile(file, buf.Bytes(), 0666)
 }
 
 func loadGridConfigAndAssign(file string) {
-	f, err := os.Open(file)
+	perp, future, fileGrids, err := readGridFile(file)
 	if err != nil {
-		log.Fatalln("open file:", err)
+		log.Fatalln("read grid file:", err)
 	}
-	defer f.Close()
 
-	r := csv.NewReader(f)
-	records, err := r.ReadAll()
+	perpName = perp
+	futureName = future
+	grids = fileGrids
+}
+
+// 读取网格文件，不修改运行中的网格
+func readGridFile(file string) (string, string, []*TradeGrid, error) {
+	records, err := readCsv(file)
 	if err != nil {
-		log.Fatalln("read csv file:", err)
-	}
-
-	perpName = records[0][0]
-	futureName = records[0][1]
-	grids = grids[:0]
-	for row := 2; row < len(records); row++ {
-		grids = append(grids, &TradeGrid{
-			Uuid:        uuid.New().String(),
-			OpenAt:      mustFloat(records[row][0]),
-			CloseAt:     mustFloat(records[row][1]),
-			OpenChance:  mustFloat(records[row][2]),
-			CloseChance: mustFloat(records[row][3]),
-			OpenOrders:  NewOrderMap(),
-			CloseOrders: NewOrderMap(),
-		})
+		return "", "", nil, err
+	}
+
+	perp, future, fileGrids, problems := parseGridRecords(file, records)
+	for _, problem := range problems {
+		if problem.Warning {
+			log.Warnln(problem.String())
+		}
+	}
+	if problems.hasError() {
+		return "", "", nil, problems
 	}
+
+	return perp, future, fileGrids, nil
+}
+
+func newGridUuid() string {
+	return uuid.New().String()
 }
 
 func loadFromSaveFile(file string) error {
@@ -237,8 +207,36 @@ func loadFromSaveFile(file string) error {
 
 	perpName = persistItem.Symbol
 	grids = persistItem.Grids
+	fundingPaid = persistItem.FundingPaid
+	fundingSyncAt = persistItem.FundingAt
+	foreignPosition = persistItem.Foreign
+	if persistItem.ForeignOrders != nil {
+		foreignOrders = persistItem.ForeignOrders
+	}
+	droppedProfit = persistItem.DroppedProfit
+	droppedFee = persistItem.DroppedFee
+	profitTotal += droppedProfit
+	takerFeeTotal = persistItem.TakerFee
+	feeTotal += droppedFee + takerFeeTotal
+	halted = persistItem.Halted
+	haltReason = persistItem.HaltReason
+	haltedAt = persistItem.HaltedAt
+	flattenPending = persistItem.Flatten
+	flattenPrice = persistItem.FlattenPrice
+	if persistItem.Report != nil {
+		reportStats = persistItem.Report
+	}
+	// 模拟盘的挂单只存在于本地订单簿，恢复后才能与网格订单对应
+	if paperBook != nil && persistItem.Paper != nil {
+		paperBook.restore(persistItem.Paper)
+	}
 	for _, grid := range grids {
-		profitTotal += grid.CloseTotal * (grid.CloseAt - grid.OpenAt)
+		// 旧版本保存的网格没有利润字段，按档位价差计算
+		if grid.Profit == 0 && grid.CloseTotal > 0 {
+			grid.Profit = grid.CloseTotal * grid.sign() * (grid.CloseAt - grid.OpenAt)
+		}
+		profitTotal += grid.Profit
+		feeTotal += grid.FeeTotal
 
 		for _, order := range grid.OpenOrders.Orders {
 			orderMap.add(order)
@@ -251,43 +249,232 @@ func loadFromSaveFile(file string) error {
 		}
 	}
 
+	// 旧版本保存的订单没有状态
+	orderMap.RangeOver(func(order *GridOrder) bool {
+		restoreBotClientId(order.ClientId)
+		if order.State == "" {
+			order.State = inferOrderState(order)
+		}
+		// 重启前未返回下单结果的订单不会再有结果，按状态未知同步
+		if order.State == OrderPendingNew {
+			order.transit(OrderUnknown, "restart")
+		}
+		return true
+	})
+
 	return nil
 }
 
 func loadBaseConfigAndAssign(file string) {
-	content, err := ioutil.ReadFile(file)
+	config, err := readBaseConfig(file)
 	if err != nil {
 		log.Fatalln("read config:", err)
 	}
-	var config Config
-	if err := json.Unmarshal(content, &config); err != nil {
-		log.Fatalln("parse config:", err)
-	}
 
 	apiKey = config.ApiKey
 	secretKey = config.SecretKey
 	subAccount = config.SubAccount
-	myName = config.MyName
-	ding = config.Ding
 
-	checkInterval = time.Duration(config.CheckInterval) * time.Millisecond
-	if checkInterval == time.Duration(0) {
-		checkInterval = time.Second * 3
+	// 配置了密钥库时从密钥库读取子账户权限
+	if config.Keystore != "" {
+		credential, err := readKeystoreCredential(config.Keystore, subAccount)
+		if err != nil {
+			log.Fatalln("read keystore:", err)
+		}
+		apiKey, secretKey = credential.ApiKey, credential.SecretKey
 	}
 
-	quickRecheckInterval = time.Duration(config.QuickRecheckInterval) * time.Millisecond
-	if quickRecheckInterval == time.Duration(0) {
-		quickRecheckInterval = time.Second * 1
+	applyBaseConfig(config)
+	applyStartupConfig(config)
+	startupConfig = config
+
+	if config.GridFile != "" && !flagPassed("grid") {
+		*gridFile = config.GridFile
+	}
+
+	client = newFtxClient(apiKey, secretKey, subAccount)
+}
+
+func readBaseConfig(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config, problems := parseConfig(file, content)
+	for _, problem := range problems {
+		if problem.Warning {
+			log.Warnln(problem.String())
+		}
+	}
+	if problems.hasError() {
+		return nil, problems
+	}
+	return config, nil
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// 解析后把字符串配置项中的 ${ENV} 替换为环境变量，变量值中的引号、冒号等字符不会影响文件解析
+func expandConfigEnv(file string, config *Config) ConfigProblems {
+	var problems ConfigProblems
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() != reflect.String {
+			continue
+		}
+		name := value.Type().Field(i).Tag.Get("json")
+		field.SetString(envPattern.ReplaceAllStringFunc(field.String(), func(match string) string {
+			env := envPattern.FindStringSubmatch(match)[1]
+			expanded, found := os.LookupEnv(env)
+			if !found {
+				problems = append(problems, &ConfigProblem{
+					File:    file,
+					Message: fmt.Sprintf("%s: environment variable %s is not set", name, env),
+				})
+			}
+			return expanded
+		}))
+	}
+	return problems
+}
+
+// 解析配置文件，按扩展名使用 yaml 或 json，字符串配置项中的 ${ENV} 替换为环境变量，未配置的项使用默认值
+func parseConfig(file string, content []byte) (*Config, ConfigProblems) {
+	var problems ConfigProblems
+	config := NewDefaultConfig()
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(content, config); err != nil {
+			return nil, append(problems, &ConfigProblem{File: file, Message: err.Error()})
+		}
+	default:
+		// 与 yaml 一样拒绝未知字段，拼错的配置项不会被静默忽略
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, append(problems, jsonProblem(file, content, err))
+		}
+	}
+	problems = append(problems, expandConfigEnv(file, config)...)
+
+	secrets := []struct {
+		name  string
+		file  string
+		value *string
+	}{
+		{"apiKeyFile", config.ApiKeyFile, &config.ApiKey},
+		{"secretKeyFile", config.SecretKeyFile, &config.SecretKey},
+		{"dingFile", config.DingFile, &config.Ding},
+	}
+	for _, secret := range secrets {
+		if secret.file == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(secret.file)
+		if err != nil {
+			problems = append(problems, &ConfigProblem{
+				File:    file,
+				Message: fmt.Sprintf("%s: %v", secret.name, err),
+			})
+			continue
+		}
+		*secret.value = strings.TrimSpace(string(b))
 	}
 
-	client = &FtxClient{
-		Client:     &http.Client{},
+	problems = append(problems, config.validate(file)...)
+	return config, problems
+}
+
+func newFtxClient(apiKey, secretKey, subAccount string) *FtxClient {
+	return &FtxClient{
+		Client:     &http.Client{Timeout: time.Second * 10}, // 超时的下单按结果未知处理，由订单同步确认
 		Api:        apiKey,
 		Secret:     []byte(secretKey),
 		Subaccount: subAccount,
 	}
 }
 
+// 启动时读取的配置，热加载时用来检查只在启动时生效的配置项是否修改
+var startupConfig *Config
+
+// 只在启动时生效的配置：下单协程、审计文件和订单号前缀等在启动时确定，运行中被其他协程读取，热加载不修改
+func applyStartupConfig(config *Config) {
+	haltFile = config.HaltFile
+	resumeFile = config.ResumeFile
+	httpAddr = config.HttpAddr
+	httpToken = config.HttpToken
+	placeWorkers = config.PlaceWorkers
+	placeQueueDepth = config.PlaceQueueDepth
+	orderAuditFile = config.OrderAuditFile
+	clientIdPrefix = config.ClientIdPrefix
+}
+
+// 热加载的配置中修改了的只在启动时生效的配置项
+func restartRequired(config *Config) []string {
+	if startupConfig == nil {
+		return nil
+	}
+	var changed []string
+	for _, field := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"haltFile", startupConfig.HaltFile, config.HaltFile},
+		{"resumeFile", startupConfig.ResumeFile, config.ResumeFile},
+		{"httpAddr", startupConfig.HttpAddr, config.HttpAddr},
+		{"httpToken", startupConfig.HttpToken, config.HttpToken},
+		{"placeWorkers", startupConfig.PlaceWorkers, config.PlaceWorkers},
+		{"placeQueueDepth", startupConfig.PlaceQueueDepth, config.PlaceQueueDepth},
+		{"orderAuditFile", startupConfig.OrderAuditFile, config.OrderAuditFile},
+		{"clientIdPrefix", startupConfig.ClientIdPrefix, config.ClientIdPrefix},
+	} {
+		if field.old != field.new {
+			changed = append(changed, field.name)
+		}
+	}
+	return changed
+}
+
+// 应用除权限和启动配置以外的配置，热加载时同样使用
+func applyBaseConfig(config *Config) {
+	myName = config.MyName
+	setDingUrl(config.Ding)
+
+	checkInterval = time.Duration(config.CheckInterval) * time.Millisecond
+	quickRecheckInterval = time.Duration(config.QuickRecheckInterval) * time.Millisecond
+	cancelRetryInterval = time.Duration(config.CancelRetryInterval) * time.Millisecond
+	cancelAlertTimeout = time.Duration(config.CancelAlertTimeout) * time.Millisecond
+	positionReportWindow = time.Duration(config.PositionReportWindow) * time.Millisecond
+
+	minSpreadAction = config.MinSpreadAction
+	fundingPauseRate = config.FundingPauseRate
+	reportPeriod = config.Report
+	positionReport = config.PositionReport
+	confirmPosition = config.ConfirmPosition
+	flattenSlice = config.FlattenSlice
+	cancelOnExit = config.CancelOnExit
+	shutdownTimeout = time.Duration(config.ShutdownTimeout) * time.Millisecond
+	reconcileInterval = time.Duration(config.ReconcileInterval) * time.Millisecond
+	positionOffset = config.PositionOffset
+	driftTolerance = config.DriftTolerance
+	reconcileAction = config.ReconcileAction
+	foreignAction = config.ForeignAction
+	trailMode = config.TrailMode
+	trailWindow = time.Duration(config.TrailWindow) * time.Millisecond
+	trailInterval = time.Duration(config.TrailInterval) * time.Millisecond
+	trailThreshold = config.TrailThreshold
+	trailMin = config.TrailMin
+	trailMax = config.TrailMax
+	volMode = config.VolMode
+	volWindow = time.Duration(config.VolWindow) * time.Millisecond
+	volMultiplier = config.VolMultiplier
+	volSpreadMin = config.VolSpreadMin
+	volSpreadMax = config.VolSpreadMax
+	marketMetaInterval = time.Duration(config.MarketMetaInterval) * time.Millisecond
+}
+
 type GridOrder struct {
 	ClientId   string
 	Id         int64
@@ -295,9 +482,20 @@ type GridOrder struct {
 	EQty       float64
 	CreateAt   time.Time
 	UpdateTime time.Time
-	DeleteAt   time.Time  `yaml:"-"`
 	Grid       *TradeGrid `yaml:"-"`
 	Side       string
+	State      OrderState
+	Reduce     bool // 只减仓订单
+
+	// 撤单跟踪，持久化以便重启后继续等待撤单确认
+	CancelAt      time.Time // 首次请求撤单的时间，零值表示未请求撤单
+	CancelSentAt  time.Time // 最近一次发送撤单的时间
+	CancelTimes   int       // 已发送撤单的次数
+	CancelAlerted bool      // 是否已经发出撤单超时告警
+}
+
+func (order *GridOrder) pendingCancel() bool {
+	return order.State == OrderPendingCancel
 }
 
 type TradeGrid struct {
@@ -309,23 +507,122 @@ type TradeGrid struct {
 
 	OpenTotal   float64
 	CloseTotal  float64
+	FeeTotal    float64 // 本网格累计支付的手续费
+	Profit      float64 // 本网格已实现的价差利润，按实际卖出价计算
+	ExitAt      float64 // 按波动率调整后的平仓价，0 表示使用 CloseAt
+	Direction   string  // long 或 short，空为 long
+	Retired     bool    // 已从网格文件移除，只平仓不再开仓
+	Successor   string  // 退役时接替的网格 Uuid，持仓占用其额度，平仓成交后还给该网格
+	Shortfall   float64 // 额度调低到持仓以下时尚未扣除的数量，从之后回到开仓机会的数量中扣除
 	OpenOrders  *OrderMap
 	CloseOrders *OrderMap
+	Row         int `yaml:"-"` // 网格文件中的行号，用于校验时定位
 }
 
 type Config struct {
-	ApiKey               string `json:"apiKey"`
-	SecretKey            string `json:"secretKey"`
-	SubAccount           string `json:"subAccount"`
-	Ding                 string `json:"ding"`
-	MyName               string `json:"myName"`
-	QuickRecheckInterval int    `json:"quickRecheckInterval"`
-	CheckInterval        int    `json:"checkInterval"`
+	// 权限，可以直接填写，也可以从文件读取
+	ApiKey        string `json:"apiKey" yaml:"apiKey"`
+	ApiKeyFile    string `json:"apiKeyFile" yaml:"apiKeyFile"`
+	SecretKey     string `json:"secretKey" yaml:"secretKey"`
+	SecretKeyFile string `json:"secretKeyFile" yaml:"secretKeyFile"`
+	SubAccount    string `json:"subAccount" yaml:"subAccount"`
+	Keystore      string `json:"keystore" yaml:"keystore"` // 加密密钥库，配置后忽略 apiKey/secretKey
+
+	// 通知
+	Ding                 string `json:"ding" yaml:"ding"`
+	DingFile             string `json:"dingFile" yaml:"dingFile"`
+	MyName               string `json:"myName" yaml:"myName"`
+	Report               string `json:"report" yaml:"report"`
+	PositionReport       bool   `json:"positionReport" yaml:"positionReport"`
+	PositionReportWindow int    `json:"positionReportWindow" yaml:"positionReportWindow"`
+
+	// 网格文件，命令行指定 -grid 时以命令行为准
+	GridFile string `json:"gridFile" yaml:"gridFile"`
+
+	// 间隔，单位毫秒
+	QuickRecheckInterval int `json:"quickRecheckInterval" yaml:"quickRecheckInterval"`
+	CheckInterval        int `json:"checkInterval" yaml:"checkInterval"`
+	CancelRetryInterval  int `json:"cancelRetryInterval" yaml:"cancelRetryInterval"`
+	CancelAlertTimeout   int `json:"cancelAlertTimeout" yaml:"cancelAlertTimeout"`
+
+	// 风控
+	MinSpreadAction  string  `json:"minSpreadAction" yaml:"minSpreadAction"`
+	FundingPauseRate float64 `json:"fundingPauseRate" yaml:"fundingPauseRate"`
+	ConfirmPosition  float64 `json:"confirmPosition" yaml:"confirmPosition"`
+
+	// 紧急停止
+	HaltFile     string  `json:"haltFile" yaml:"haltFile"`
+	ResumeFile   string  `json:"resumeFile" yaml:"resumeFile"`
+	FlattenSlice float64 `json:"flattenSlice" yaml:"flattenSlice"`
+	HttpAddr     string  `json:"httpAddr" yaml:"httpAddr"`
+	HttpToken    string  `json:"httpToken" yaml:"httpToken"`
+
+	// 退出
+	CancelOnExit    bool `json:"cancelOnExit" yaml:"cancelOnExit"`
+	ShutdownTimeout int  `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+
+	// 下单并发数和每个并发的排队上限
+	PlaceWorkers    int `json:"placeWorkers" yaml:"placeWorkers"`
+	PlaceQueueDepth int `json:"placeQueueDepth" yaml:"placeQueueDepth"`
+
+	// 订单状态转换审计日志，为空时不记录
+	OrderAuditFile string `json:"orderAuditFile" yaml:"orderAuditFile"`
+
+	// 对账
+	ReconcileInterval int     `json:"reconcileInterval" yaml:"reconcileInterval"`
+	PositionOffset    float64 `json:"positionOffset" yaml:"positionOffset"`
+	DriftTolerance    float64 `json:"driftTolerance" yaml:"driftTolerance"`
+	ReconcileAction   string  `json:"reconcileAction" yaml:"reconcileAction"`
+
+	// 手动交易
+	ClientIdPrefix string `json:"clientIdPrefix" yaml:"clientIdPrefix"`
+	ForeignAction  string `json:"foreignAction" yaml:"foreignAction"`
+
+	// 跟踪网格，时间单位毫秒
+	TrailMode      string  `json:"trailMode" yaml:"trailMode"`
+	TrailWindow    int     `json:"trailWindow" yaml:"trailWindow"`
+	TrailInterval  int     `json:"trailInterval" yaml:"trailInterval"`
+	TrailThreshold float64 `json:"trailThreshold" yaml:"trailThreshold"`
+	TrailMin       float64 `json:"trailMin" yaml:"trailMin"`
+	TrailMax       float64 `json:"trailMax" yaml:"trailMax"`
+
+	// 波动率调整平仓价差，时间单位毫秒，价差为开仓价的比例
+	VolMode       string  `json:"volMode" yaml:"volMode"`
+	VolWindow     int     `json:"volWindow" yaml:"volWindow"`
+	VolMultiplier float64 `json:"volMultiplier" yaml:"volMultiplier"`
+	VolSpreadMin  float64 `json:"volSpreadMin" yaml:"volSpreadMin"`
+	VolSpreadMax  float64 `json:"volSpreadMax" yaml:"volSpreadMax"`
+
+	// 市场交易单位刷新间隔，毫秒
+	MarketMetaInterval int `json:"marketMetaInterval" yaml:"marketMetaInterval"`
 }
 
+// 未配置时的默认值，检查间隔沿用原来未配置时的 1 秒和 3 秒
 func NewDefaultConfig() *Config {
 	return &Config{
-		QuickRecheckInterval: 500,
-		CheckInterval:        1500,
+		QuickRecheckInterval: 1000,
+		CheckInterval:        3000,
+		CancelRetryInterval:  20000,
+		CancelAlertTimeout:   120000,
+		MinSpreadAction:      "warn",
+		PositionReportWindow: 10000,
+		HaltFile:             "HALT",
+		ResumeFile:           "RESUME",
+		ShutdownTimeout:      10000,
+		PlaceWorkers:         4,
+		PlaceQueueDepth:      50,
+		OrderAuditFile:       "order_audit.log",
+		ReconcileInterval:    60000,
+		ReconcileAction:      "alert",
+		ClientIdPrefix:       "grid-",
+		ForeignAction:        "ignore",
+		TrailWindow:          3600000,
+		TrailInterval:        300000,
+		TrailThreshold:       0.02,
+		VolWindow:            3600000,
+		VolMultiplier:        1,
+		VolSpreadMin:         0.002,
+		VolSpreadMax:         0.05,
+		MarketMetaInterval:   300000,
 	}
 }
@@ -11,6 +11,9 @@ import (
 )
 
 var (
+	// 通知地址可能被热加载修改，通知在其他协程发送，读写需要加锁
+	dingMutex sync.RWMutex
+
 	dingInitOnce sync.Once
 
 	dingAsyncBuffer *bytes.Buffer
@@ -24,6 +27,18 @@ type TextMessage struct {
 	} `json:"text"`
 }
 
+func dingUrl() string {
+	dingMutex.RLock()
+	defer dingMutex.RUnlock()
+	return ding
+}
+
+func setDingUrl(url string) {
+	dingMutex.Lock()
+	defer dingMutex.Unlock()
+	ding = url
+}
+
 func SendDingtalkText(url string, text string) {
 	SendDingtalk(url, "", text)
 }
@@ -65,7 +80,7 @@ func SendDingTalkAsync(text string) {
 					if dingAsyncBuffer.Len() == 0 {
 						return
 					}
-					SendDingtalk(ding, "", fmt.Sprintf("----告警----\n%s", dingAsyncBuffer.Bytes()))
+					SendDingtalk(dingUrl(), "", fmt.Sprintf("----告警----\n%s", dingAsyncBuffer.Bytes()))
 					dingAsyncBuffer.Reset()
 				}()
 			}
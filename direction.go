@@ -0,0 +1,88 @@
+package main
+
+// 网格方向：做多网格先买后卖，做空网格先卖后买，CloseAt 低于 OpenAt
+const (
+	GridLong  = "long"
+	GridShort = "short"
+)
+
+func (grid *TradeGrid) short() bool {
+	return grid.Direction == GridShort
+}
+
+// 持仓方向，做多为 1，做空为 -1
+func (grid *TradeGrid) sign() float64 {
+	if grid.short() {
+		return -1
+	}
+	return 1
+}
+
+// 开仓方向
+func (grid *TradeGrid) openSide() string {
+	if grid.short() {
+		return "sell"
+	}
+	return "buy"
+}
+
+// 平仓方向
+func (grid *TradeGrid) closeSide() string {
+	if grid.short() {
+		return "buy"
+	}
+	return "sell"
+}
+
+// 订单方向是否是开仓
+func (grid *TradeGrid) isOpenSide(side string) bool {
+	return side == grid.openSide()
+}
+
+// 网格当前持仓，做空为负
+func (grid *TradeGrid) position() float64 {
+	return grid.sign() * (grid.OpenTotal - grid.CloseTotal)
+}
+
+// 订单价格是否在盘口附近，买单在买一下方 5% 内，卖单在卖一上方 5% 内
+func nearBook(side string, price, bid, ask float64) bool {
+	if side == "buy" {
+		return price <= bid && price > bid*0.95
+	}
+	return price >= ask && price < ask*1.05
+}
+
+// 订单价格是否离盘口太远，买单低于买一 8%，卖单高于卖一 8%
+func farFromBook(side string, price, bid, ask float64) bool {
+	if side == "buy" {
+		return price < bid*0.92
+	}
+	return price > ask*1.08
+}
+
+// 挂单中尚未成交的开仓数量，side 为 buy 时统计做多网格，sell 时统计做空网格
+func restingOpenQty(side string) float64 {
+	var qty float64
+	for _, grid := range grids {
+		if grid.openSide() != side {
+			continue
+		}
+		for _, order := range grid.OpenOrders.Orders {
+			qty += order.Qty - order.EQty
+		}
+	}
+	return qty
+}
+
+// 挂单中尚未成交的只减仓数量，side 为 sell 时减少多头持仓，buy 时减少空头持仓
+func restingReduceQty(side string) float64 {
+	var qty float64
+	for _, grid := range grids {
+		for _, order := range grid.CloseOrders.Orders {
+			if order.Reduce && order.Side == side {
+				qty += order.Qty - order.EQty
+			}
+		}
+	}
+	return qty
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// 交易所从该时间之前不会有本策略的记录
+var exportStartTime = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 每页请求的记录数
+var exportPageSize = 100
+
+// 导出市场全部成交和订单历史到 csv，供对账使用
+func exportHistory(market string, dir string) error {
+	fills, err := fetchAllFills(market)
+	if err != nil {
+		return err
+	}
+	if err := writeFillsCsv(fmt.Sprintf("%s/%s_fills.csv", dir, market), fills); err != nil {
+		return err
+	}
+
+	orders, err := fetchAllOrders(market)
+	if err != nil {
+		return err
+	}
+	if err := writeOrdersCsv(fmt.Sprintf("%s/%s_orders.csv", dir, market), orders); err != nil {
+		return err
+	}
+
+	log.WithField("fills", len(fills)).WithField("orders", len(orders)).Infoln("ExportHistory")
+	return nil
+}
+
+// 交易所按时间倒序分页返回，以最早一条的时间作为下一页的结束时间。
+// 结束时间精确到秒，同一秒内的记录超过一页时无法继续翻页，返回错误而不是导出不完整的记录
+func fetchAllFills(market string) ([]*Fill, error) {
+	var all []*Fill
+	seen := map[int64]bool{}
+	end := time.Now()
+	for {
+		fills, err := client.getFills(market, exportStartTime, end, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		cursor := end.Unix()
+
+		added := 0
+		for _, fill := range fills {
+			if seen[fill.ID] {
+				continue
+			}
+			seen[fill.ID] = true
+			all = append(all, fill)
+			added++
+			if fill.Time.Before(end) {
+				end = fill.Time
+			}
+		}
+		if len(fills) >= exportPageSize && end.Unix() == cursor {
+			return nil, fmt.Errorf("more than %d fills at %v, export would be incomplete", exportPageSize, end)
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Time.Before(all[j].Time)
+	})
+	return all, nil
+}
+
+func fetchAllOrders(market string) ([]*Order, error) {
+	var all []*Order
+	seen := map[int64]bool{}
+	end := time.Now()
+	for {
+		orders, err := client.getOrderHistory(market, exportStartTime, end, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		cursor := end.Unix()
+
+		added := 0
+		for _, order := range orders {
+			if seen[order.ID] {
+				continue
+			}
+			seen[order.ID] = true
+			all = append(all, order)
+			added++
+			if order.CreatedAt.Before(end) {
+				end = order.CreatedAt
+			}
+		}
+		if len(orders) >= exportPageSize && end.Unix() == cursor {
+			return nil, fmt.Errorf("more than %d orders at %v, export would be incomplete", exportPageSize, end)
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].CreatedAt.Before(all[j].CreatedAt)
+	})
+	return all, nil
+}
+
+func writeFillsCsv(file string, fills []*Fill) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "id", "orderId", "tradeId", "market", "side", "price", "size", "fee", "feeRate", "feeCurrency", "liquidity"})
+	for _, fill := range fills {
+		w.Write([]string{
+			fill.Time.Format(time.RFC3339),
+			fmt.Sprint(fill.ID),
+			fmt.Sprint(fill.OrderID),
+			fmt.Sprint(fill.TradeID),
+			fill.Market,
+			fill.Side,
+			fmt.Sprint(fill.Price),
+			fmt.Sprint(fill.Size),
+			fmt.Sprint(fill.Fee),
+			fmt.Sprint(fill.FeeRate),
+			fill.FeeCurrency,
+			fill.Liquidity,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeOrdersCsv(file string, orders []*Order) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"createdAt", "id", "clientId", "market", "side", "type", "price", "size", "filledSize", "avgFillPrice", "status", "reduceOnly", "postOnly"})
+	for _, order := range orders {
+		w.Write([]string{
+			order.CreatedAt.Format(time.RFC3339),
+			fmt.Sprint(order.ID),
+			order.ClientID,
+			order.Market,
+			order.Side,
+			order.Type,
+			fmt.Sprint(order.Price),
+			fmt.Sprint(order.Size),
+			fmt.Sprint(order.FilledSize),
+			fmt.Sprint(order.AvgFillPrice),
+			order.Status,
+			fmt.Sprint(order.ReduceOnly),
+			fmt.Sprint(order.PostOnly),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// 模拟盘读取不到账户费率时使用的保守费率
+	fallbackMakerFee = 0.0007
+	fallbackTakerFee = 0.0007
+
+	// 平仓和对账修正的 IOC 订单不属于任何网格，手续费单独累计并持久化
+	takerFeeTotal float64
+	// IOC 订单已计算手续费的成交数量，订单关闭后删除
+	takerOrders = map[string]float64{}
+)
+
+// 从账户信息读取挂单和吃单费率
+func loadFees() error {
+	account, err := client.getAccount()
+	if err != nil {
+		return err
+	}
+
+	makerFee = account.MakerFee
+	takerFee = account.TakerFee
+	log.WithField("makerFee", makerFee).WithField("takerFee", takerFee).Infoln("LoadFees")
+	return nil
+}
+
+// post only 订单只会挂单成交，其他订单按吃单费率计算
+func feeRate(order *Order) float64 {
+	if order.PostOnly {
+		return makerFee
+	}
+	return takerFee
+}
+
+// 平仓和对账修正使用的 IOC 订单号，带机器人前缀，成交按吃单费率计入手续费
+func newTakerClientId() string {
+	return clientIdPrefix + "ioc-" + uuid.New().String()
+}
+
+func isTakerOrder(clientId string) bool {
+	return clientId != "" && strings.HasPrefix(clientId, clientIdPrefix+"ioc-")
+}
+
+// 处理 IOC 订单的成交，只计算手续费，持仓差异由对账处理，紧急平仓的盈亏在清零网格持仓时计入
+func onTakerOrder(order *Order) {
+	// IOC 订单的价格让出了滑点，按成交均价计算
+	price := order.AvgFillPrice
+	if price == 0 {
+		price = order.Price
+	}
+	if delta := order.FilledSize - takerOrders[order.ClientID]; delta > 0 {
+		fee := delta * price * feeRate(order)
+		takerFeeTotal += fee
+		feeTotal += fee
+		reportStats.addFill(delta, price, fee, 0)
+		persistGrids()
+	}
+
+	if order.Status == "closed" {
+		delete(takerOrders, order.ClientID)
+	} else {
+		takerOrders[order.ClientID] = order.FilledSize
+	}
+}
+
+// 一个来回扣除手续费后每单位的价差
+func (grid *TradeGrid) netSpread() float64 {
+	return grid.sign()*(grid.CloseAt-grid.OpenAt) - makerFee*(grid.OpenAt+grid.CloseAt)
+}
+
+// 网格已实现的净利润
+func (grid *TradeGrid) netProfit() float64 {
+	return grid.Profit - grid.FeeTotal
+}
+
+// 扣除手续费和资金费后的净利润
+func netProfitTotal() float64 {
+	return profitTotal - feeTotal - fundingPaid
+}
+
+// 检查网格价差是否覆盖来回手续费，配置为 refuse 时返回错误
+func checkGridFees(grids []*TradeGrid) error {
+	var problems []string
+	for index, grid := range grids {
+		if grid.Retired || grid.netSpread() > 0 {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("[%03d] %v-%v netSpread=%0.6v",
+			index, grid.OpenAt, grid.CloseAt, grid.netSpread()))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("网格价差不足以覆盖手续费(maker=%v):\n%s", makerFee, strings.Join(problems, "\n"))
+	if minSpreadAction == "refuse" {
+		return fmt.Errorf("%s", msg)
+	}
+	log.Warnln(msg)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 机器人订单的客户端订单号前缀，用于区分同一子账户中的手动订单
+	clientIdPrefix = "grid-"
+	// 发现手动订单或成交时的处理：ignore 只通知，pause 暂停网格下单，adjust 把手动成交计入预期持仓
+	foreignAction = "ignore"
+
+	// 手动订单导致的暂停，热加载或重启后恢复
+	foreignPaused bool
+	// 手动成交累计的持仓，adjust 时计入预期持仓，持久化
+	foreignPosition float64
+	// 已发现的手动订单，持久化以免重启后重复计算已处理的成交
+	foreignOrders = map[int64]*ForeignOrder{}
+	// 关闭的手动订单保留的时间，避免延迟的挂单列表重复计算成交
+	foreignClosedRetention = time.Minute * 5
+	// 从状态文件恢复的不带前缀的订单号，旧版本或修改前缀前提交的机器人订单，离开订单表后的推送不视为手动订单
+	restoredClientIds = map[string]bool{}
+)
+
+type ForeignOrder struct {
+	Filled   float64   // 已处理的成交数量
+	ClosedAt time.Time // 订单关闭的时间，零值表示未关闭
+}
+
+func newClientId() string {
+	return clientIdPrefix + uuid.New().String()
+}
+
+// 是否是机器人提交的订单，没有客户端订单号的一定是手动订单，没有前缀时其他订单无法区分，全部视为机器人订单
+func isBotOrder(clientId string) bool {
+	return clientId != "" && (clientIdPrefix == "" || strings.HasPrefix(clientId, clientIdPrefix) || restoredClientIds[clientId])
+}
+
+func restoreBotClientId(clientId string) {
+	if clientId != "" && clientIdPrefix != "" && !strings.HasPrefix(clientId, clientIdPrefix) {
+		restoredClientIds[clientId] = true
+	}
+}
+
+// 处理不属于网格的订单更新，其他市场和机器人自己的非网格订单不处理
+func onForeignOrder(order *Order) {
+	if order.Market != perpName || isBotOrder(order.ClientID) {
+		return
+	}
+
+	record, seen := foreignOrders[order.ID]
+	if !seen {
+		log.WithFields(logrus.Fields{
+			"id":       order.ID,
+			"clientId": order.ClientID,
+			"side":     order.Side,
+			"price":    order.Price,
+			"size":     order.Size,
+			"action":   foreignAction,
+		}).Warnln("ForeignOrder")
+		SendDingTalkAsync(fmt.Sprintln("发现手动订单:", perpName, order.ID, order.Side, order.Price, order.Size, "处理：", foreignAction))
+		pauseForForeign()
+		record = &ForeignOrder{}
+		foreignOrders[order.ID] = record
+	}
+
+	if delta := order.FilledSize - record.Filled; delta > 0 {
+		record.Filled = order.FilledSize
+		onForeignFill(order, delta)
+	}
+	if order.Status == "closed" && record.ClosedAt.IsZero() {
+		record.ClosedAt = time.Now()
+	}
+}
+
+// 删除关闭超过保留时间的手动订单记录
+func pruneForeignOrders() {
+	for id, record := range foreignOrders {
+		if !record.ClosedAt.IsZero() && time.Now().Sub(record.ClosedAt) > foreignClosedRetention {
+			delete(foreignOrders, id)
+		}
+	}
+}
+
+func onForeignFill(order *Order, delta float64) {
+	signed := delta
+	if order.Side == "sell" {
+		signed = -delta
+	}
+
+	log.WithFields(logrus.Fields{
+		"id":     order.ID,
+		"side":   order.Side,
+		"price":  order.Price,
+		"size":   delta,
+		"action": foreignAction,
+	}).Warnln("ForeignFill")
+
+	if foreignAction == "adjust" {
+		foreignPosition += signed
+		persistGrids()
+	}
+	pauseForForeign()
+
+	SendDingTalkAsync(fmt.Sprintln("发现手动成交:", perpName, order.Side, order.Price, delta,
+		"处理：", foreignAction, "手动持仓：", foreignPosition))
+}
+
+func pauseForForeign() {
+	if foreignAction != "pause" || foreignPaused {
+		return
+	}
+	foreignPaused = true
+	log.Warnln("ForeignPause")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 手动订单已处理的成交随状态保存，重启后同一订单的推送不会重复计入手动持仓，关闭的订单过期后删除
+func TestForeignFillsSurviveRestart(t *testing.T) {
+	defer func(file, action string) {
+		saveFile, foreignAction = file, action
+	}(saveFile, foreignAction)
+
+	saveFile = filepath.Join(t.TempDir(), "save.yaml")
+	foreignAction = "adjust"
+	perpName = "TEST-PERP"
+	paperBook = nil
+	grids = []*TradeGrid{}
+	orderMap = NewOrderMap()
+	foreignOrders = map[int64]*ForeignOrder{}
+	foreignPosition = 0
+
+	order := &Order{ID: 1, Market: perpName, Side: "buy", Price: 100, Size: 5, FilledSize: 2, Status: "open"}
+	onForeignOrder(order)
+	if foreignPosition != 2 {
+		t.Fatalf("foreignPosition = %v, want 2", foreignPosition)
+	}
+	persistGrids()
+
+	// 模拟重启
+	foreignOrders = map[int64]*ForeignOrder{}
+	foreignPosition = 0
+	if err := loadFromSaveFile(saveFile); err != nil {
+		t.Fatal(err)
+	}
+	onForeignOrder(order)
+	if foreignPosition != 2 {
+		t.Fatalf("foreignPosition after restart = %v, want 2", foreignPosition)
+	}
+
+	order.FilledSize, order.Status = 5, "closed"
+	onForeignOrder(order)
+	if foreignPosition != 5 {
+		t.Fatalf("foreignPosition after close = %v, want 5", foreignPosition)
+	}
+
+	foreignOrders[order.ID].ClosedAt = time.Now().Add(-foreignClosedRetention - time.Second)
+	pruneForeignOrders()
+	if len(foreignOrders) != 0 {
+		t.Fatalf("closed foreign order not pruned: %v", foreignOrders)
+	}
+}
+
+// 从状态文件恢复的不带前缀的订单离开订单表后，延迟的推送仍视为机器人订单
+func TestRestoredClientIdIsBotOrder(t *testing.T) {
+	defer func(file, prefix string) {
+		saveFile, clientIdPrefix = file, prefix
+	}(saveFile, clientIdPrefix)
+
+	saveFile = filepath.Join(t.TempDir(), "save.yaml")
+	clientIdPrefix = ""
+	perpName = "TEST-PERP"
+	paperBook = nil
+	orderMap = NewOrderMap()
+	grid := &TradeGrid{Uuid: newGridUuid(), OpenAt: 100, CloseAt: 101, OpenOrders: NewOrderMap(), CloseOrders: NewOrderMap()}
+	order := &GridOrder{ClientId: newClientId(), Qty: 1, Grid: grid, Side: "buy", State: OrderOpen}
+	grid.OpenOrders.add(order)
+	grids = []*TradeGrid{grid}
+	persistGrids()
+
+	// 重启时已经配置了前缀
+	clientIdPrefix = "grid-"
+	orderMap = NewOrderMap()
+	restoredClientIds = map[string]bool{}
+	if err := loadFromSaveFile(saveFile); err != nil {
+		t.Fatal(err)
+	}
+	orderMap.remove(order.ClientId)
+	if !isBotOrder(order.ClientId) {
+		t.Fatalf("restored client id %v treated as foreign", order.ClientId)
+	}
+	if isBotOrder("manual-1") {
+		t.Fatal("unknown client id treated as bot order")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 累计资金费，正数为支付，负数为收取
+	fundingPaid float64
+	// 已统计的最后一笔资金费时间
+	fundingSyncAt time.Time
+	// 最近一次查询到的资金费率和预测费率
+	fundingRate     float64
+	nextFundingRate float64
+	// 预测资金费率对持仓方向不利超过该值时暂停该方向开仓，0 表示不暂停
+	fundingPauseRate   float64
+	fundingPaused      bool
+	fundingPausedShort bool
+
+	fundingSyncInterval = time.Minute * 10
+	lastFundingSyncTime time.Time
+)
+
+// 定时在主循环外查询资金费支付记录和费率，结果发回主循环累计
+func syncFunding() {
+	if time.Now().Sub(lastFundingSyncTime) < fundingSyncInterval {
+		return
+	}
+	lastFundingSyncTime = time.Now()
+
+	// 首次运行只统计启动之后的资金费
+	if fundingSyncAt.IsZero() {
+		fundingSyncAt = time.Now()
+	}
+
+	since := fundingSyncAt
+	runAsync(func() {
+		event := &EventFunding{}
+		defer func() {
+			eventChan <- event
+		}()
+
+		payments, err := client.getFundingPayments(perpName, since)
+		if err != nil {
+			logrus.WithError(err).Errorln("GetFundingPayments")
+		}
+		event.Payments = payments
+
+		rates, err := client.getFundingRates(perpName)
+		if err != nil {
+			logrus.WithError(err).Errorln("GetFundingRates")
+		} else if len(rates) > 0 {
+			event.Rate, event.HasRate = rates[0].Rate, true
+		}
+
+		stats, err := client.getFutureStats(perpName)
+		if err != nil {
+			logrus.WithError(err).Errorln("GetFutureStats")
+			return
+		}
+		event.NextRate, event.HasNextRate = stats.NextFundingRate, true
+	})
+}
+
+func onFunding(event *EventFunding) {
+	// 交易所按时间倒序返回，全部统计完再把同步时间推进到最新一条
+	latest := fundingSyncAt
+	for _, payment := range event.Payments {
+		if !payment.Time.After(fundingSyncAt) {
+			continue
+		}
+		fundingPaid += payment.Payment
+		if payment.Time.After(latest) {
+			latest = payment.Time
+		}
+		logrus.WithFields(logrus.Fields{
+			"payment": payment.Payment,
+			"rate":    payment.Rate,
+			"total":   fundingPaid,
+		}).Infoln("FundingPayment")
+	}
+	fundingSyncAt = latest
+
+	if event.HasRate {
+		fundingRate = event.Rate
+	}
+	if !event.HasNextRate {
+		return
+	}
+	nextFundingRate = event.NextRate
+
+	// 预测费率为正时多头支付，为负时空头支付
+	paused := fundingPauseRate > 0 && nextFundingRate >= fundingPauseRate
+	if paused != fundingPaused {
+		fundingPaused = paused
+		if paused {
+			SendDingTalkAsync(fmt.Sprintln("预测资金费率过高，暂停做多开仓:", perpName, nextFundingRate))
+		} else {
+			SendDingTalkAsync(fmt.Sprintln("预测资金费率恢复，继续做多开仓:", perpName, nextFundingRate))
+		}
+	}
+	pausedShort := fundingPauseRate > 0 && nextFundingRate <= -fundingPauseRate
+	if pausedShort != fundingPausedShort {
+		fundingPausedShort = pausedShort
+		if pausedShort {
+			SendDingTalkAsync(fmt.Sprintln("预测资金费率过低，暂停做空开仓:", perpName, nextFundingRate))
+		} else {
+			SendDingTalkAsync(fmt.Sprintln("预测资金费率恢复，继续做空开仓:", perpName, nextFundingRate))
+		}
+	}
+}
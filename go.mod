@@ -8,5 +8,7 @@ require (
 	github.com/lvhuat/textformatter v1.0.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/tidwall/gjson v1.6.1
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/term v0.0.0-20201117132131-f5c789dd3221
 	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// 紧急停止：停止网格、撤销全部订单，可选平仓
+type EventHalt struct {
+	Reason  string
+	Flatten bool
+}
+
+// 紧急平仓完成，网格持仓需要清零
+type EventFlattened struct {
+	Position float64
+	// 平仓订单的成交均价，没有成交时为 0
+	Price float64
+}
+
+var (
+	// 已停止交易，持久化以保证重启后不会继续交易
+	halted     bool
+	haltReason string
+	haltedAt   time.Time
+	// 已平仓但网格持仓尚未清零，等待全部网格订单关闭后清零，持久化
+	flattenPending bool
+	// 平仓成交均价，清零网格持仓时按该价格计算亏损，持久化
+	flattenPrice float64
+	// 已经通知过网格订单未关闭不能恢复
+	resumeBlocked bool
+
+	// 存在该文件时停止交易，文件内容为 flatten 时同时平仓
+	haltFile = "HALT"
+	// 停止后存在该文件时恢复交易，运行中的实例和下次启动都会检查
+	resumeFile = "RESUME"
+	// 平仓每笔订单的最大数量，0 表示一次平掉
+	flattenSlice float64
+	// 接收停止指令的 http 地址和口令，地址为空表示不启动
+	httpAddr  string
+	httpToken string
+
+	// halt 命令等待运行中的实例确认停止的时间，超时后直接撤单和平仓
+	haltAckTimeout = time.Second * 30
+	// 状态文件超过该时间没有保存，视为没有运行中的实例
+	instanceStaleAfter = time.Second * 10
+	// 停止时等待已经发出的下单请求完成的最长时间
+	haltPlaceWait = time.Second * 15
+)
+
+// 停止交易并持久化，在主循环外撤销市场内全部订单，需要时分批平仓
+func haltTrading(reason string, flatten bool) {
+	if !halted {
+		halted = true
+		haltReason = reason
+		haltedAt = time.Now()
+		persistGrids()
+	}
+	log.WithField("reason", reason).WithField("flatten", flatten).Warnln("Halt")
+
+	msg := fmt.Sprintln("【紧急停止】", myName, perpName, "原因：", reason)
+	// 只平掉网格持有的仓位，底仓和手动订单的持仓保留
+	slice, keep := flattenSlice, positionOffset+foreignPosition
+	// 队列中的订单不再发送，已经发出的下单请求完成后再撤单，避免撤单之后又有新挂单
+	placer.block()
+	runAsync(func() {
+		if !placer.wait(haltPlaceWait) {
+			log.Warnln("Halt: in-flight placements not finished")
+			msg += fmt.Sprintln("等待下单请求超时，撤单后可能仍有挂单")
+		}
+		if err := cancelAllOrders(); err != nil {
+			logrus.WithError(err).Errorln("HaltCancelAll")
+			msg += fmt.Sprintln("撤单失败：", err)
+		}
+
+		if flatten {
+			position, price, err := flattenPosition(slice, keep)
+			if err != nil {
+				logrus.WithError(err).Errorln("HaltFlatten")
+				msg += fmt.Sprintln("平仓失败：", err, "剩余持仓：", position)
+			} else {
+				msg += fmt.Sprintln("平仓完成，剩余持仓：", position, "成交均价：", price)
+				eventChan <- &EventFlattened{Position: position, Price: price}
+			}
+		}
+
+		SendDingtalkText(dingUrl(), msg)
+	})
+}
+
+func onFlattened(event *EventFlattened) {
+	log.WithField("position", event.Position).WithField("price", event.Price).Warnln("Flattened")
+	flattenPending = true
+	flattenPrice = event.Price
+	persistGrids()
+	resetFlattenedGrids()
+}
+
+// 平仓后清零网格持仓，按平仓均价计入利润，平仓机会还给开仓机会，避免恢复后为已经不存在的持仓挂平仓单。
+// 撤单确认时未成交部分会回到平仓机会，所以等全部网格订单关闭后再清零
+func resetFlattenedGrids() {
+	if !flattenPending || len(orderMap.Orders) > 0 {
+		return
+	}
+	for _, grid := range grids {
+		if qty := grid.inventory(); qty > 0 {
+			if flattenPrice > 0 {
+				profit := qty * grid.sign() * (flattenPrice - grid.OpenAt)
+				grid.Profit += profit
+				profitTotal += profit
+				reportStats.Profit += profit
+			} else {
+				log.WithField("grid", grid.Uuid).WithField("qty", qty).Warnln("ResetFlattenedGrids: no flatten price")
+			}
+		}
+		// 退役网格的持仓占用接替网格的额度，与平仓成交一样还给接替的网格
+		if successor := findGrid(grid.Successor); grid.Retired && successor != nil && !successor.Retired {
+			successor.restoreOpenChance(grid.CloseChance)
+		}
+		grid.restoreOpenChance(grid.CloseChance)
+		grid.CloseChance = 0
+		grid.CloseTotal = grid.OpenTotal
+	}
+	flattenPending = false
+	flattenPrice = 0
+	persistGrids()
+	log.Infoln("ResetFlattenedGrids")
+}
+
+func cancelAllOrders() error {
+	if paperBook != nil {
+		paperBook.cancelAll()
+		return nil
+	}
+
+	resp, err := client.deleteAllOrders(perpName)
+	var result string
+	return parseResultWrap(err, resp, &result)
+}
+
+// 使用 IOC 订单分批平仓到 keep，不穿过零点的合约订单使用只减仓，返回剩余持仓和成交均价
+func flattenPosition(slice, keep float64) (float64, float64, error) {
+	var filled, amount float64
+	average := func() float64 {
+		if filled == 0 {
+			return 0
+		}
+		return amount / filled
+	}
+
+	for attempt := 0; attempt < 20; attempt++ {
+		current, err := currentPosition()
+		if err != nil {
+			return current, average(), err
+		}
+		position := current - keep
+
+		perp, err := fetchTicker()
+		if err != nil {
+			return position, average(), err
+		}
+		// 现货不能卖空，余额低于底仓时不买入
+		if math.Abs(position) < perp.SizeIncrement || (isSpot() && position < 0) {
+			return position, average(), nil
+		}
+
+		size := math.Abs(position)
+		if slice > 0 && size > slice {
+			size = slice
+		}
+
+		// 价格让出 1% 保证成交
+		side, price, quote := "sell", perp.Bid*0.99, perp.Bid
+		if position < 0 {
+			side, price, quote = "buy", perp.Ask*1.01, perp.Ask
+		}
+		price = roundTo(price, perp.PriceIncrement)
+		size = roundTo(size, perp.SizeIncrement)
+		// 保留的仓位与当前持仓方向相反时需要穿过零点，不能使用只减仓
+		reduce := !isSpot() && size <= math.Abs(current) && (current > 0) == (side == "sell")
+
+		log.WithFields(logrus.Fields{
+			"side":     side,
+			"price":    price,
+			"size":     size,
+			"position": position,
+			"reduce":   reduce,
+		}).Warnln("Flatten")
+
+		if paperBook != nil {
+			filled += size
+			amount += size * quote
+			if side == "buy" {
+				size = -size
+			}
+			paperBook.reducePosition(size)
+			continue
+		}
+
+		clientId := newTakerClientId()
+		if _, err := client.placeIocOrder(clientId, perpName, side, price, size, reduce); err != nil {
+			logrus.WithError(err).Errorln("FlattenOrder")
+		}
+		time.Sleep(time.Second)
+		if order, err := fetchOrderByClient(clientId); err == nil && order.FilledSize > 0 {
+			filled += order.FilledSize
+			amount += order.FilledSize * order.AvgFillPrice
+		}
+	}
+
+	position, err := currentPosition()
+	if err != nil {
+		return position, average(), err
+	}
+	return position - keep, average(), fmt.Errorf("position not flattened after retries")
+}
+
+// 在主循环中调用，存在停止文件时停止交易
+func checkHaltFile() {
+	if halted || haltFile == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(haltFile)
+	if err != nil {
+		return
+	}
+	haltTrading("halt file "+haltFile, strings.TrimSpace(string(b)) == "flatten")
+}
+
+// 在主循环中调用，停止后存在恢复文件时恢复交易
+func checkResumeFile() {
+	if !halted || resumeFile == "" {
+		return
+	}
+	if _, err := os.Stat(resumeFile); err != nil {
+		return
+	}
+	resumeTrading()
+}
+
+// 清除停止状态，删除停止文件和恢复文件，下个周期重新挂单。平仓后网格持仓未清零时不恢复
+func resumeTrading() {
+	resetFlattenedGrids()
+	if flattenPending {
+		if !resumeBlocked {
+			resumeBlocked = true
+			log.Warnln("Resume: grid orders not closed after flatten")
+			SendDingTalkAsync(fmt.Sprintln("平仓后仍有网格订单未确认关闭，暂不恢复交易:", myName, perpName, "订单数：", len(orderMap.Orders)))
+		}
+		return
+	}
+	resumeBlocked = false
+
+	for _, file := range []string{haltFile, resumeFile} {
+		if file == "" {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).Errorln("Resume")
+			return
+		}
+	}
+	halted = false
+	haltReason = ""
+	haltedAt = time.Time{}
+	placer.unblock()
+	persistGrids()
+
+	log.Infoln("Resumed")
+	SendDingTalkAsync(fmt.Sprintln("恢复交易:", myName, perpName))
+}
+
+// POST /halt?flatten=1，口令放在 X-Token 请求头
+func serveHalt(eventChan chan interface{}) {
+	if httpAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/halt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if httpToken != "" && r.Header.Get("X-Token") != httpToken {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		eventChan <- &EventHalt{
+			Reason:  "http " + r.RemoteAddr,
+			Flatten: r.URL.Query().Get("flatten") == "1",
+		}
+		fmt.Fprintln(w, "halting")
+	})
+
+	go func() {
+		if err := http.ListenAndServe(httpAddr, mux); err != nil {
+			logrus.WithError(err).Errorln("HaltServer")
+		}
+	}()
+}
+
+// halt 子命令：写入停止文件，由运行中的实例停止交易、撤单和平仓。
+// 没有运行中的实例确认时直接撤单和平仓，不修改状态文件，下次启动时停止文件使实例进入停止状态
+func runHalt(args []string) error {
+	fs := flag.NewFlagSet("halt", flag.ExitOnError)
+	flatten := fs.Bool("flatten", false, "同时平掉市场内的持仓")
+	paper := fs.Bool("paper", false, "停止模拟盘实例")
+	fs.Parse(args)
+
+	loadBaseConfigAndAssign(*cfgFile)
+	if *paper {
+		usePaperFiles()
+	}
+	if haltFile == "" {
+		return fmt.Errorf("haltFile is not configured")
+	}
+
+	content := ""
+	if *flatten {
+		content = "flatten"
+	}
+	since := time.Now()
+	if err := ioutil.WriteFile(haltFile, []byte(content), 0666); err != nil {
+		return err
+	}
+	if resumeFile != "" {
+		os.Remove(resumeFile)
+	}
+	fmt.Println("已写入", haltFile)
+
+	if waitInstanceHalt(saveFile, since) {
+		fmt.Println("运行中的实例已停止交易")
+		return nil
+	}
+
+	// 模拟盘的订单和持仓只存在于运行中的实例，没有实例时不需要撤单
+	if *paper {
+		fmt.Println("没有运行中的模拟盘实例确认停止，下次启动时停止交易")
+		return nil
+	}
+
+	// 没有运行中的实例，直接撤单和平仓
+	fmt.Println("没有运行中的实例确认停止，直接撤销全部订单")
+	item, err := readPersistItem(saveFile)
+	if err == nil && item.Symbol != "" {
+		perpName = item.Symbol
+	} else {
+		item = nil
+		loadGridConfigAndAssign(*gridFile)
+	}
+	if err := cancelAllOrders(); err != nil {
+		return fmt.Errorf("cancel all orders: %v", err)
+	}
+	if !*flatten {
+		return nil
+	}
+
+	// 只平掉网格持有的仓位，手动订单的持仓从状态文件读取
+	keep := positionOffset
+	if item != nil {
+		keep += item.Foreign
+	}
+	position, _, err := flattenPosition(flattenSlice, keep)
+	if err != nil {
+		return fmt.Errorf("flatten: %v, position %v", err, position)
+	}
+	fmt.Println("平仓完成，剩余持仓：", position)
+	return nil
+}
+
+// 读取状态文件，不修改运行中的状态
+func readPersistItem(file string) (*GridPersistItem, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var item GridPersistItem
+	if err := yaml.Unmarshal(b, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// 等待运行中的实例处理停止文件，运行中的实例每个周期都会保存状态。
+// 状态文件长时间没有更新、实例在写入停止文件前已经停止(不会再处理停止文件)或超时返回 false
+func waitInstanceHalt(file string, since time.Time) bool {
+	deadline := since.Add(haltAckTimeout)
+	for first := true; time.Now().Before(deadline); first = false {
+		item, err := readPersistItem(file)
+		if err != nil || time.Now().Sub(item.Time) > instanceStaleAfter {
+			return false
+		}
+		if item.Halted && item.HaltedAt.After(since) {
+			return true
+		}
+		if first && item.Halted {
+			return false
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+	return false
+}
+
+// resume 子命令：写入恢复文件，由运行中的实例或下次启动时清除停止状态，不修改状态文件
+func runResume(args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	paper := fs.Bool("paper", false, "恢复模拟盘实例")
+	fs.Parse(args)
+
+	loadBaseConfigAndAssign(*cfgFile)
+	if *paper {
+		usePaperFiles()
+	}
+	if resumeFile == "" {
+		return fmt.Errorf("resumeFile is not configured")
+	}
+	if err := ioutil.WriteFile(resumeFile, nil, 0666); err != nil {
+		return err
+	}
+	fmt.Println("已写入", resumeFile, "，停止的实例将在下个周期恢复交易，未运行时下次启动恢复")
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+// 平仓后清零网格持仓时，按平仓均价计入每个网格的亏损
+func TestResetFlattenedGridsBooksLoss(t *testing.T) {
+	saveFile = ""
+	orderAuditFile = ""
+	orderMap = NewOrderMap()
+	profitTotal = 0
+
+	long := &TradeGrid{Uuid: newGridUuid(), OpenAt: 100, CloseAt: 101, Direction: GridLong,
+		CloseChance: 2, OpenTotal: 2, OpenOrders: NewOrderMap(), CloseOrders: NewOrderMap()}
+	short := &TradeGrid{Uuid: newGridUuid(), OpenAt: 90, CloseAt: 89, Direction: GridShort,
+		CloseChance: 1, OpenTotal: 1, OpenOrders: NewOrderMap(), CloseOrders: NewOrderMap()}
+	grids = []*TradeGrid{long, short}
+
+	onFlattened(&EventFlattened{Position: 0, Price: 95})
+
+	if long.Profit != -10 || short.Profit != -5 || profitTotal != -15 {
+		t.Fatalf("long=%v short=%v total=%v, want -10 -5 -15", long.Profit, short.Profit, profitTotal)
+	}
+	if long.inventory() != 0 || long.OpenChance != 2 || flattenPending || flattenPrice != 0 {
+		t.Fatalf("grid not reset: inventory=%v open=%v pending=%v", long.inventory(), long.OpenChance, flattenPending)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 加密保存各子账户的 API 密钥，密钥由口令经 PBKDF2 派生，使用 AES-GCM 加密
+type Keystore struct {
+	Version    int                       `json:"version"`
+	Salt       []byte                    `json:"salt"`
+	Iterations int                       `json:"iterations"`
+	Accounts   map[string]*KeystoreEntry `json:"accounts"`
+}
+
+type KeystoreEntry struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+type Credential struct {
+	ApiKey    string `json:"apiKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+const (
+	keystoreIterations = 200000
+	// 口令优先从该环境变量读取，否则从标准输入读取
+	keystorePassEnv = "STRATEGY_KEYSTORE_PASS"
+)
+
+func (store *Keystore) aead(passphrase string) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), store.Salt, store.Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newKeystore() (*Keystore, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		Version:    1,
+		Salt:       salt,
+		Iterations: keystoreIterations,
+		Accounts:   map[string]*KeystoreEntry{},
+	}, nil
+}
+
+func loadKeystore(file string) (*Keystore, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var store Keystore
+	if err := json.Unmarshal(b, &store); err != nil {
+		return nil, err
+	}
+	if store.Accounts == nil {
+		store.Accounts = map[string]*KeystoreEntry{}
+	}
+	return &store, nil
+}
+
+func (store *Keystore) save(file string) error {
+	b, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// 子账户为空字符串表示主账户
+func (store *Keystore) get(passphrase, account string) (*Credential, error) {
+	entry, found := store.Accounts[account]
+	if !found {
+		return nil, fmt.Errorf("account %q not found in keystore", account)
+	}
+	aead, err := store.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, entry.Nonce, entry.Data, []byte(account))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %q: wrong passphrase or corrupted keystore", account)
+	}
+	var credential Credential
+	if err := json.Unmarshal(plain, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// 已有条目时用口令解密一次，避免用错误的口令写入
+func (store *Keystore) verify(passphrase string) error {
+	for name := range store.Accounts {
+		_, err := store.get(passphrase, name)
+		return err
+	}
+	return nil
+}
+
+func (store *Keystore) put(passphrase, account string, credential *Credential) error {
+	if err := store.verify(passphrase); err != nil {
+		return err
+	}
+
+	aead, err := store.aead(passphrase)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	store.Accounts[account] = &KeystoreEntry{
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plain, []byte(account)),
+	}
+	return nil
+}
+
+func keystorePassphrase() string {
+	if pass, found := os.LookupEnv(keystorePassEnv); found {
+		return pass
+	}
+	return promptSecret("keystore passphrase: ")
+}
+
+// 从密钥库读取子账户的权限
+func readKeystoreCredential(file, account string) (*Credential, error) {
+	store, err := loadKeystore(file)
+	if err != nil {
+		return nil, err
+	}
+	return store.get(keystorePassphrase(), account)
+}
+
+// keystore 子命令：keystore [-file keystore.json] add|rotate|list [subAccount]
+func runKeystore(args []string) error {
+	fs := flag.NewFlagSet("keystore", flag.ExitOnError)
+	file := fs.String("file", "keystore.json", "密钥库文件")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: keystore [-file keystore.json] add|rotate|list [subAccount]")
+	}
+	command, account := fs.Arg(0), fs.Arg(1)
+
+	store, err := loadKeystore(*file)
+	if os.IsNotExist(err) && command == "add" {
+		store, err = newKeystore()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		var names []string
+		for name := range store.Accounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%q\n", name)
+		}
+		return nil
+	case "add", "rotate":
+		_, exists := store.Accounts[account]
+		if command == "add" && exists {
+			return fmt.Errorf("account %q already exists, use rotate", account)
+		}
+		if command == "rotate" && !exists {
+			return fmt.Errorf("account %q not found, use add", account)
+		}
+	default:
+		return fmt.Errorf("unknown keystore command %q", command)
+	}
+
+	passphrase := keystorePassphrase()
+	if err := store.verify(passphrase); err != nil {
+		return err
+	}
+	credential := &Credential{
+		ApiKey:    promptSecret("apiKey: "),
+		SecretKey: promptSecret("secretKey: "),
+	}
+	if credential.ApiKey == "" || credential.SecretKey == "" {
+		return fmt.Errorf("apiKey and secretKey must not be empty")
+	}
+	if err := store.put(passphrase, account, credential); err != nil {
+		return err
+	}
+	if err := store.save(*file); err != nil {
+		return err
+	}
+	log.WithField("account", account).Infoln("KeystoreSaved", command)
+	return nil
+}
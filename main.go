@@ -1,15 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"time"
 
 	"github.com/lvhuat/textformatter"
 	"github.com/sirupsen/logrus"
-	"github.com/tidwall/gjson"
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,106 +17,244 @@ var (
 )
 
 var gridFile = flag.String("grid", "grid.csv", "网格文件")
-var cfgFile = flag.String("cfg", "config.json", "基本配置文件")
-var testMode = flag.Bool("test", false, "仅打印不会下单，不会执行网格")
-var mf = flag.Bool("mf", false, "仅监控保证金率")
+var cfgFile = flag.String("cfg", "config.json", "基本配置文件，支持 json 和 yaml")
 
-type EventRejectOrder struct {
-	ClientId string
-	Side     string
+// 启动计划超过确认阈值时不再询问
+var autoConfirm bool
+
+// 命令行是否显式指定了该参数
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
 }
 
 type GridPersistItem struct {
-	Time        time.Time
-	Ask         float64
-	Bid         float64
-	Symbol      string
-	ProfitTotal float64
-	Grids       []*TradeGrid
+	Time          time.Time
+	Ask           float64
+	Bid           float64
+	Symbol        string
+	ProfitTotal   float64
+	FeeTotal      float64
+	FundingPaid   float64
+	FundingAt     time.Time
+	Foreign       float64
+	ForeignOrders map[int64]*ForeignOrder
+	DroppedProfit float64
+	DroppedFee    float64
+	TakerFee      float64
+	NetProfit     float64
+	Report        *ReportStats
+	Halted        bool
+	HaltReason    string
+	HaltedAt      time.Time
+	Flatten       bool
+	FlattenPrice  float64
+	Grids         []*TradeGrid
+	Paper         *PaperState
 }
 
 func persistGrids() {
+	// 回测不保存状态
+	if saveFile == "" {
+		return
+	}
+
+	// 模拟盘同时保存本地订单簿
+	var paper *PaperState
+	if paperBook != nil {
+		paper = paperBook.snapshot()
+	}
+
 	d, err := yaml.Marshal(&GridPersistItem{
-		Grids:       grids,
-		Time:        time.Now(),
-		Symbol:      perpName,
-		Ask:         ask1,
-		Bid:         bid1,
-		ProfitTotal: profitTotal,
+		Grids:         grids,
+		Time:          time.Now(),
+		Symbol:        perpName,
+		Ask:           ask1,
+		Bid:           bid1,
+		ProfitTotal:   profitTotal,
+		FeeTotal:      feeTotal,
+		FundingPaid:   fundingPaid,
+		FundingAt:     fundingSyncAt,
+		Foreign:       foreignPosition,
+		ForeignOrders: foreignOrders,
+		DroppedProfit: droppedProfit,
+		DroppedFee:    droppedFee,
+		TakerFee:      takerFeeTotal,
+		NetProfit:     netProfitTotal(),
+		Report:        reportStats,
+		Halted:        halted,
+		HaltReason:    haltReason,
+		HaltedAt:      haltedAt,
+		Flatten:       flattenPending,
+		FlattenPrice:  flattenPrice,
+		Paper:         paper,
 	})
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
-	ioutil.WriteFile("save.yaml", d, 0666)
+	// 先写临时文件再改名，避免写入中途退出损坏状态文件
+	tmp := saveFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, d, 0666); err != nil {
+		log.WithError(err).Errorln("PersistGrids")
+		return
+	}
+	if err := os.Rename(tmp, saveFile); err != nil {
+		log.WithError(err).Errorln("PersistGrids")
+	}
+}
+
+// 处理主循环中的事件
+func handleEvent(event interface{}) {
+	switch event.(type) {
+	case *Order:
+		onOrderChange(event.(*Order))
+	case *EventPlaceResult:
+		onPlaceResult(event.(*EventPlaceResult))
+	case *EventReload:
+		reload(event.(*EventReload).Reason)
+	case *EventHalt:
+		data := event.(*EventHalt)
+		haltTrading(data.Reason, data.Flatten)
+	case *EventFlattened:
+		onFlattened(event.(*EventFlattened))
+	case *EventTicker:
+		onTicker(event.(*EventTicker))
+	case *EventOrdersSnapshot:
+		onOrdersSnapshot(event.(*EventOrdersSnapshot))
+	case *EventFunding:
+		onFunding(event.(*EventFunding))
+	case *EventReconcile:
+		onReconcile(event.(*EventReconcile))
+	case *EventTrail:
+		onTrail(event.(*EventTrail))
+	case *EventMarketMeta:
+		onMarketMeta(event.(*EventMarketMeta))
+	case *EventSpotBalance:
+		onSpotBalance(event.(*EventSpotBalance))
+	}
 }
 
 func main() {
 	logrus.SetFormatter(&textformatter.TextFormatter{})
 
+	flag.Usage = usage
 	flag.Parse()
 
-	if *cfgFile != "" {
-		loadBaseConfigAndAssign(*cfgFile)
+	command, args := "run", []string{}
+	if flag.NArg() > 0 {
+		command, args = flag.Arg(0), flag.Args()[1:]
 	}
 
-	eventChan := make(chan interface{}, 1000)
-
-	go func() {
-		for {
-			wsclient := WebsocketClient{
-				apiKey:     apiKey,
-				secret:     []byte(secretKey),
-				subAccount: subAccount,
-				quit:       make(chan interface{}),
-			}
+	if err := runCommand(command, args); err != nil {
+		log.Fatalln(command+":", err)
+	}
+}
 
-			if err := wsclient.dial(false); err != nil {
-				logrus.WithError(err).Errorln("DialWebsocketFailed")
-				time.Sleep(time.Second)
-				continue
-			}
+// 执行网格，paper 为 true 时使用模拟盘
+func runStrategy(paper bool, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.BoolVar(&autoConfirm, "yes", false, "启动计划超过确认阈值时不再询问")
+	testMode := fs.Bool("test", false, "仅打印首个周期的下单计划，不会下单")
+	fs.Parse(args)
+	if *testMode {
+		autoConfirm = true
+	}
 
-			wsclient.ping()
-			wsclient.login()
-			wsclient.subOrder()
-			wsclient.onOrderChange = func(body []byte) {
-				order := &Order{}
-				raw := gjson.GetBytes(body, "data").Raw
-				json.Unmarshal([]byte(raw), &order)
-				if order.ClientID == "" {
-					return
-				}
-				eventChan <- order
-			}
+	loadBaseConfigAndAssign(*cfgFile)
 
-			wsclient.waitFinished()
-			logrus.Errorln("WebsocketStop")
-			time.Sleep(time.Second)
+	// 读取账户费率用于计算净利润和校验网格价差，实盘读取失败时不启动
+	if err := loadFees(); err != nil {
+		if !paper {
+			return fmt.Errorf("load fees: %v", err)
 		}
-	}()
+		makerFee, takerFee = fallbackMakerFee, fallbackTakerFee
+		log.WithError(err).WithField("makerFee", makerFee).WithField("takerFee", takerFee).Warnln("LoadFeesFallback")
+	}
 
-	RejectOrder = func(clientId, side string) {
-		eventChan <- &EventRejectOrder{
-			ClientId: clientId,
-			Side:     side,
-		}
+	eventChan = make(chan interface{}, 1000)
+
+	if paper {
+		usePaperFiles()
+		paperBook = NewPaperBook(func(order *Order) {
+			eventChan <- order
+		})
+		paperBook.startDelivery()
 	}
 
-	if *mf {
-		mfLoop()
-		return
+	// 试运行只读取状态并打印计划，不写状态文件和订单审计日志
+	stateFile := saveFile
+	if *testMode {
+		saveFile = ""
+		orderAuditFile = ""
+	}
+
+	// 模拟盘的订单更新由本地订单簿产生
+	if paperBook == nil && !*testMode {
+		go runWebsocket(eventChan)
 	}
 
+	// 收到 SIGINT/SIGTERM 时优雅退出
+	if !*testMode {
+		watchShutdownSignal(eventChan)
+	}
+
+	// 下单在工作协程中执行，结果发送回主循环
+	placer = NewPlacePipeline(placeWorkers, placeQueueDepth, func(result *EventPlaceResult) {
+		eventChan <- result
+	})
+
 	if *gridFile != "" {
 		loadGridConfigAndAssign(*gridFile)
 	}
 
-	if _, err := os.Stat("./save.yaml"); os.IsNotExist(err) {
-		if *gridFile != "" {
-			loadGridConfigAndAssign(*gridFile)
+	// 同时存在运行状态和网格文件时，以运行状态为基础合并网格文件的修改
+	if _, err := os.Stat(stateFile); err == nil {
+		if err := loadFromSaveFile(stateFile); err != nil {
+			log.Fatalln("load save file:", err)
 		}
-	} else {
-		loadFromSaveFile("save.yaml")
+		// 跟踪网格以保存的网格为准，运行中修改网格文件才会合并，撤单在确认启动计划后发送
+		if *gridFile != "" && trailMode == "" {
+			holdCancels = true
+			if err := mergeGridFile(*gridFile); err != nil {
+				log.Fatalln("merge grid file:", err)
+			}
+		}
+	}
+
+	// 网格来自网格文件或保存的状态，都需要校验价差
+	if err := checkGridFees(grids); err != nil {
+		return err
+	}
+
+	// 紧急停止后需要先执行 resume 才能重新交易，试运行不清除停止状态
+	if halted && resumeFile != "" && !*testMode {
+		if _, err := os.Stat(resumeFile); err == nil {
+			resumeTrading()
+		}
+	}
+	if halted {
+		return fmt.Errorf("halted at %v: %s, run resume first", haltedAt.Format(time.RFC3339), haltReason)
+	}
+
+	// 下单前按市场交易单位取整，运行中定时刷新
+	meta, err := fetchMarketMeta()
+	if err != nil {
+		return fmt.Errorf("fetch market meta: %v", err)
+	}
+	setMarketMeta(meta)
+
+	if !*testMode {
+		// 停止指令可以来自 http 接口和停止文件
+		serveHalt(eventChan)
+
+		// 配置文件变更或收到 SIGHUP 时热加载
+		markConfigFiles()
+		watchReloadSignal(eventChan)
 	}
 
 	// 打印网格配置
@@ -125,6 +262,15 @@ func main() {
 	// 打印持仓
 	debugPositions()
 
+	// 打印首个周期的下单计划
+	if !confirmStartupPlan() {
+		return fmt.Errorf("startup plan not confirmed")
+	}
+	if *testMode {
+		return nil
+	}
+	releaseHeldCancels()
+
 	writeGridCurrent()
 	for i := 3; i > 0; i-- {
 		log.Infoln("Counting ", i)
@@ -133,63 +279,65 @@ func main() {
 
 	log.Infoln("Good luck!")
 
-	// go monitorPosition()
-	// go mfLoop()
-
-	// 执行网格
+	// 执行网格，网格和订单状态只在这个循环中修改
 	wait := checkInterval
 	lastSyncOrderTime := time.Now()
 	for {
 		persistGrids()
 		select {
 		case <-time.After(wait):
-			check()
-			writeGridCurrent()
+			if !halted && !stopping {
+				requestTicker()
+			}
 			wait = quickRecheckInterval
 		case event := <-eventChan:
-			switch event.(type) {
-			case *Order:
-				onOrderChange(event.(*Order))
-			case *EventRejectOrder:
-				data := event.(*EventRejectOrder)
-				onRejectOrder(data.ClientId, data.Side)
+			if shutdown, ok := event.(*EventShutdown); ok {
+				gracefulShutdown(eventChan, shutdown.Signal)
+				return nil
 			}
+			handleEvent(event)
 			continue
 		}
 
-		if time.Now().Sub(lastSyncOrderTime) < time.Second*5 {
-			time.Sleep(wait)
-			continue
+		// 定时刷新订单状态，未能及时同步的订单将采用单个同步的方式同步
+		if time.Now().Sub(lastSyncOrderTime) >= time.Second*5 {
+			lastSyncOrderTime = time.Now()
+			requestOrderSync(staleOrders())
 		}
 
-		// 定时刷新订单状态
-		orders, err := client.getOrders(perpName)
-		if err != nil {
-			logrus.WithError(err).Errorln("GetOpenOrders")
-			continue
-		}
-		for _, order := range orders {
-			onOrderChange(order)
+		// 撤单未确认的订单重发撤单并告警
+		checkPendingCancels()
+
+		// 定时对比实际持仓和网格持仓，检查非网格挂单
+		checkReconcile()
+
+		// 定时刷新现货计价币种余额，买单不超过余额
+		checkSpotBalance()
+
+		// 定时同步资金费，模拟盘和现货没有资金费
+		if paperBook == nil && !isSpot() {
+			syncFunding()
 		}
 
-		// 未能及时同步的订单，将采用单个同步的方式同步
-		orderMap.RangeOver(func(order *GridOrder) bool {
-			if time.Now().Sub(order.UpdateTime) < time.Second*3 {
-				return true
-			}
-			ftxOrder, err := client.getOrderByClient(order.ClientId)
-			if err != nil {
-				switch err.Error() {
-				case "Order not found":
-					RejectOrder(order.ClientId, order.Side)
-				}
-
-				logrus.WithError(err).Errorln("GetOrder", order.ClientId)
-				return true
-			}
-			onOrderChange(ftxOrder)
-			return true
-		})
+		// 定时发送收益报告
+		checkReport()
+
+		// 成交后合并发送持仓报告
+		checkPositionReport()
+
+		// 检查停止文件，平仓后网格订单全部关闭时清零网格持仓
+		checkHaltFile()
+		resetFlattenedGrids()
+		checkResumeFile()
+
+		// 定时刷新市场交易单位
+		checkMarketMeta()
+
+		// 跟踪网格，参考价偏离时平移网格
+		checkTrail()
 
+		// 检查配置文件变更，清理已完成平仓的退役网格
+		checkConfigFiles()
+		dropRetiredGrids()
 	}
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 市场交易单位的刷新间隔
+	marketMetaInterval = time.Minute * 5
+
+	marketMeta         *MarketMeta
+	lastMarketMetaTime time.Time
+	marketMetaPending  bool
+
+	// 已经通知过剩余数量无法下单的网格
+	residualAlerted = map[string]bool{}
+)
+
+// 市场的交易单位和限价范围，定时刷新，下单前按此取整
+type MarketMeta struct {
+	PriceIncrement float64
+	SizeIncrement  float64
+	MinSize        float64
+	// 交易所允许的限价范围，0 表示不限制
+	LowerBound float64
+	UpperBound float64
+}
+
+type EventMarketMeta struct {
+	Meta *MarketMeta
+	Err  error
+}
+
+// 从交易所读取市场信息，合约的限价范围取自 futures 接口，之后随每次盘口更新
+func fetchMarketMeta() (*MarketMeta, error) {
+	market, err := client.getMarket(perpName)
+	if err != nil {
+		return nil, err
+	}
+	meta := &MarketMeta{
+		PriceIncrement: market.PriceIncrement,
+		SizeIncrement:  market.SizeIncrement,
+		MinSize:        market.MinProvideSize,
+	}
+	if meta.MinSize < meta.SizeIncrement {
+		meta.MinSize = meta.SizeIncrement
+	}
+	if meta.PriceIncrement <= 0 || meta.SizeIncrement <= 0 {
+		return nil, fmt.Errorf("invalid increments of %s: price %v size %v", perpName, meta.PriceIncrement, meta.SizeIncrement)
+	}
+
+	if !isSpot() {
+		future := &FuturesItem{}
+		resp, err := client.getFuture(perpName)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseResult(resp, &future); err != nil {
+			return nil, err
+		}
+		meta.LowerBound, meta.UpperBound = future.LowerBound, future.UpperBound
+	}
+	return meta, nil
+}
+
+// 定时异步刷新市场信息
+func checkMarketMeta() {
+	if marketMetaPending || time.Now().Sub(lastMarketMetaTime) < marketMetaInterval {
+		return
+	}
+	lastMarketMetaTime = time.Now()
+	marketMetaPending = true
+	runAsync(func() {
+		meta, err := fetchMarketMeta()
+		eventChan <- &EventMarketMeta{Meta: meta, Err: err}
+	})
+}
+
+// 刷新失败时继续使用缓存，交易单位变化时通知
+func onMarketMeta(event *EventMarketMeta) {
+	marketMetaPending = false
+	if event.Err != nil {
+		logrus.WithError(event.Err).Errorln("FetchMarketMeta")
+		return
+	}
+	old := marketMeta
+	setMarketMeta(event.Meta)
+	if old != nil && (old.PriceIncrement != event.Meta.PriceIncrement || old.SizeIncrement != event.Meta.SizeIncrement ||
+		old.MinSize != event.Meta.MinSize) {
+		log.WithFields(logrus.Fields{
+			"priceIncrement": event.Meta.PriceIncrement,
+			"sizeIncrement":  event.Meta.SizeIncrement,
+			"minSize":        event.Meta.MinSize,
+		}).Warnln("MarketMetaChanged")
+		SendDingTalkAsync(fmt.Sprintln("市场交易单位变化:", perpName, "价格：", old.PriceIncrement, "->", event.Meta.PriceIncrement,
+			"数量：", old.SizeIncrement, "->", event.Meta.SizeIncrement, "最小数量：", old.MinSize, "->", event.Meta.MinSize))
+	}
+}
+
+func setMarketMeta(meta *MarketMeta) {
+	marketMeta = meta
+	priceIncrement = meta.PriceIncrement
+	lastMarketMetaTime = time.Now()
+}
+
+// 按市场交易单位取整订单，买单价格向下、卖单价格向上取整，数量向下取整，
+// 取整后不足最小数量或超出限价范围时返回 false
+func (meta *MarketMeta) roundOrder(side string, price, size float64) (float64, float64, bool) {
+	if side == "buy" {
+		price = floorTo(price, meta.PriceIncrement)
+	} else {
+		price = ceilTo(price, meta.PriceIncrement)
+	}
+	size = floorTo(size, meta.SizeIncrement)
+	if price <= 0 || size < meta.MinSize {
+		return price, size, false
+	}
+	if (meta.LowerBound > 0 && price < meta.LowerBound) || (meta.UpperBound > 0 && price > meta.UpperBound) {
+		log.WithFields(logrus.Fields{
+			"side":  side,
+			"price": price,
+			"lower": meta.LowerBound,
+			"upper": meta.UpperBound,
+		}).Debugln("PriceOutOfBand")
+		return price, size, false
+	}
+	return price, size, true
+}
+
+// 合约的限价范围随价格变化，用每次盘口返回的范围更新，现货没有限价范围
+func updatePriceBand(ticker *FuturesItem) {
+	if marketMeta == nil || isSpot() {
+		return
+	}
+	marketMeta.LowerBound, marketMeta.UpperBound = ticker.LowerBound, ticker.UpperBound
+}
+
+// 网格没有挂单，开仓和平仓机会取整后都不足最小下单数量时，剩余的持仓既不能平仓也不会与新的成交合并，
+// 首次出现时通知手动处理
+func checkResiduals(meta *MarketMeta) {
+	if meta == nil {
+		return
+	}
+	for _, grid := range grids {
+		stuck := grid.CloseChance > 0 && floorTo(grid.CloseChance, meta.SizeIncrement) < meta.MinSize &&
+			(grid.Retired || floorTo(grid.OpenChance, meta.SizeIncrement) < meta.MinSize) &&
+			len(grid.OpenOrders.Orders) == 0 && len(grid.CloseOrders.Orders) == 0
+		if !stuck {
+			delete(residualAlerted, grid.Uuid)
+			continue
+		}
+		if residualAlerted[grid.Uuid] {
+			continue
+		}
+		residualAlerted[grid.Uuid] = true
+		log.WithFields(logrus.Fields{
+			"openAt":      grid.OpenAt,
+			"closeAt":     grid.CloseAt,
+			"openChance":  grid.OpenChance,
+			"closeChance": grid.CloseChance,
+			"minSize":     meta.MinSize,
+		}).Warnln("GridResidual")
+		SendDingTalkAsync(fmt.Sprintln("网格剩余数量不足最小下单数量，需要手动处理:", perpName, grid.OpenAt, grid.CloseAt,
+			"平仓数量：", grid.CloseChance, "开仓数量：", grid.OpenChance, "最小数量：", meta.MinSize))
+	}
+}
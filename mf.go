@@ -43,5 +43,5 @@ func sendDingMF(accountInfo *AccountInfo) {
 		fmt.Fprintf(buf, "     - %-4v %-10v net:%+v pnl:%+v\n", pos.Side, pos.Future, pos.NetSize, pos.UnrealizedPnl)
 	}
 
-	SendDingtalkText(ding, buf.String())
+	SendDingtalkText(dingUrl(), buf.String())
 }
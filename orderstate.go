@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 网格订单状态
+type OrderState string
+
+const (
+	OrderPendingNew      OrderState = "PendingNew"      // 已提交，交易所尚未确认
+	OrderOpen            OrderState = "Open"            // 挂单中
+	OrderPartiallyFilled OrderState = "PartiallyFilled" // 部分成交
+	OrderPendingCancel   OrderState = "PendingCancel"   // 已请求撤单，等待关闭
+	OrderFilled          OrderState = "Filled"          // 全部成交
+	OrderCancelled       OrderState = "Cancelled"       // 已撤销
+	OrderRejected        OrderState = "Rejected"        // 下单失败
+	OrderUnknown         OrderState = "Unknown"         // 状态不一致，等待同步确认
+)
+
+// 允许的状态转换，终态不能再转换
+var orderTransitions = map[OrderState][]OrderState{
+	OrderPendingNew:      {OrderOpen, OrderPartiallyFilled, OrderPendingCancel, OrderFilled, OrderCancelled, OrderRejected, OrderUnknown},
+	OrderOpen:            {OrderPartiallyFilled, OrderPendingCancel, OrderFilled, OrderCancelled, OrderUnknown},
+	OrderPartiallyFilled: {OrderPendingCancel, OrderFilled, OrderCancelled, OrderUnknown},
+	OrderPendingCancel:   {OrderFilled, OrderCancelled, OrderUnknown},
+	OrderUnknown:         {OrderOpen, OrderPartiallyFilled, OrderPendingCancel, OrderFilled, OrderCancelled, OrderRejected},
+}
+
+func (state OrderState) terminal() bool {
+	return state == OrderFilled || state == OrderCancelled || state == OrderRejected
+}
+
+func (state OrderState) canTransit(to OrderState) bool {
+	for _, next := range orderTransitions[state] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 订单状态转换审计日志，为空时不记录
+var orderAuditFile = "order_audit.log"
+
+var orderAudit *os.File
+
+// 一条状态转换记录
+type OrderAuditEntry struct {
+	Time     time.Time  `json:"time"`
+	ClientId string     `json:"clientId"`
+	Id       int64      `json:"id"`
+	Side     string     `json:"side"`
+	OpenAt   float64    `json:"openAt"`
+	CloseAt  float64    `json:"closeAt"`
+	From     OrderState `json:"from"`
+	To       OrderState `json:"to"`
+	Reason   string     `json:"reason"`
+}
+
+// 转换订单状态，转换不合法时不修改状态并返回 false
+func (order *GridOrder) transit(to OrderState, reason string) bool {
+	from := order.State
+	if from == to {
+		return true
+	}
+	if !from.canTransit(to) {
+		log.WithFields(logrus.Fields{
+			"clientId": order.ClientId,
+			"from":     from,
+			"to":       to,
+			"reason":   reason,
+		}).Warnln("InvalidOrderTransition")
+		return false
+	}
+
+	order.State = to
+	auditOrder(order, from, to, reason)
+	return true
+}
+
+func auditOrder(order *GridOrder, from, to OrderState, reason string) {
+	entry := &OrderAuditEntry{
+		Time:     time.Now(),
+		ClientId: order.ClientId,
+		Id:       order.Id,
+		Side:     order.Side,
+		From:     from,
+		To:       to,
+		Reason:   reason,
+	}
+	if order.Grid != nil {
+		entry.OpenAt, entry.CloseAt = order.Grid.OpenAt, order.Grid.CloseAt
+	}
+
+	log.WithFields(logrus.Fields{
+		"clientId": entry.ClientId,
+		"from":     from,
+		"to":       to,
+		"reason":   reason,
+	}).Debugln("OrderTransition")
+
+	if orderAuditFile == "" {
+		return
+	}
+	if orderAudit == nil {
+		f, err := os.OpenFile(orderAuditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			log.WithError(err).Errorln("OpenOrderAudit")
+			return
+		}
+		orderAudit = f
+	}
+
+	b, _ := json.Marshal(entry)
+	if _, err := orderAudit.Write(append(b, '\n')); err != nil {
+		log.WithError(err).Errorln("WriteOrderAudit")
+	}
+}
+
+// 根据交易所订单推断成交或关闭后的状态
+func exchangeOrderState(order *Order) OrderState {
+	switch {
+	case order.Status == "closed" && order.FilledSize >= order.Size:
+		return OrderFilled
+	case order.Status == "closed":
+		return OrderCancelled
+	case order.FilledSize > 0:
+		return OrderPartiallyFilled
+	default:
+		return OrderOpen
+	}
+}
+
+// 旧版本保存的订单没有状态，根据字段推断
+func inferOrderState(order *GridOrder) OrderState {
+	switch {
+	case !order.CancelAt.IsZero():
+		return OrderPendingCancel
+	case order.Id == 0:
+		return OrderPendingNew
+	case order.EQty > 0:
+		return OrderPartiallyFilled
+	default:
+		return OrderOpen
+	}
+}
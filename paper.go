@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 模拟撮合的订单簿，使用真实行情撮合本地订单，订单更新与 websocket 推送格式一致
+type PaperBook struct {
+	mutex    sync.Mutex
+	nextId   int64
+	orders   map[string]*Order // clientId -> order
+	position float64
+	bid      float64
+	ask      float64
+
+	// 已关闭订单的关闭时间，保留一段时间供按客户端订单号查询
+	closedAt map[string]time.Time
+
+	onOrder func(order *Order)
+	// 异步投递时订单更新先进入队列，由投递协程按顺序发出，避免持锁发送
+	async bool
+	queue []*Order
+	ready *sync.Cond
+}
+
+// 模拟盘模式下不为空
+var paperBook *PaperBook
+
+// 已关闭的模拟订单保留的时间，之后从订单簿删除
+var paperClosedRetention = time.Minute * 10
+
+// 模拟盘状态，保存在模拟盘的状态文件中，重启后恢复挂单和持仓
+type PaperState struct {
+	NextId   int64
+	Position float64
+	Orders   []*Order
+}
+
+// 模拟盘使用单独的状态文件、审计日志和停止/恢复文件，与同目录的实盘实例互不影响
+func usePaperFiles() {
+	saveFile = "save_paper.yaml"
+	orderAuditFile = paperFile(orderAuditFile)
+	haltFile = paperFile(haltFile)
+	resumeFile = paperFile(resumeFile)
+}
+
+// 文件名加上 paper_ 前缀，未配置的文件保持为空
+func paperFile(file string) string {
+	if file == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(file), "paper_"+filepath.Base(file))
+}
+
+func NewPaperBook(onOrder func(order *Order)) *PaperBook {
+	book := &PaperBook{
+		nextId:   time.Now().Unix(),
+		orders:   map[string]*Order{},
+		closedAt: map[string]time.Time{},
+		onOrder:  onOrder,
+	}
+	book.ready = sync.NewCond(&book.mutex)
+	return book
+}
+
+// 启动投递协程，之后订单更新在独立协程中按产生顺序回调
+func (book *PaperBook) startDelivery() {
+	book.mutex.Lock()
+	book.async = true
+	book.mutex.Unlock()
+
+	go func() {
+		for {
+			book.mutex.Lock()
+			for len(book.queue) == 0 {
+				book.ready.Wait()
+			}
+			order := book.queue[0]
+			book.queue = book.queue[1:]
+			book.mutex.Unlock()
+
+			book.onOrder(order)
+		}
+	}()
+}
+
+// 调用时必须持有锁
+func (book *PaperBook) emit(order *Order) {
+	if order.Status == "closed" {
+		book.closedAt[order.ClientID] = time.Now()
+	}
+	copied := *order
+	if book.async {
+		book.queue = append(book.queue, &copied)
+		book.ready.Signal()
+		return
+	}
+	if book.onOrder != nil {
+		book.onOrder(&copied)
+	}
+}
+
+func (book *PaperBook) place(clientId string, market string, side string, price float64, _type string, size float64, reduce bool, post bool) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if _, found := book.orders[clientId]; found {
+		return fmt.Errorf("Duplicate client order ID")
+	}
+
+	book.nextId++
+	order := &Order{
+		CreatedAt:     time.Now(),
+		ID:            book.nextId,
+		Market:        market,
+		Future:        market,
+		Price:         price,
+		Side:          side,
+		Size:          size,
+		RemainingSize: size,
+		Status:        "open",
+		Type:          _type,
+		ReduceOnly:    reduce,
+		PostOnly:      post,
+		ClientID:      clientId,
+	}
+	book.orders[clientId] = order
+
+	// post only 订单会吃单时交易所直接撤销
+	if post && ((side == "buy" && book.ask > 0 && price >= book.ask) || (side == "sell" && price <= book.bid)) {
+		order.Status = "closed"
+		order.RemainingSize = 0
+	}
+
+	book.emit(order)
+	return nil
+}
+
+func (book *PaperBook) cancel(clientId string) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	order, found := book.orders[clientId]
+	if !found {
+		return fmt.Errorf("Order not found")
+	}
+	if order.Status == "closed" {
+		return fmt.Errorf("Order already closed")
+	}
+
+	order.Status = "closed"
+	order.RemainingSize = 0
+	book.emit(order)
+	return nil
+}
+
+// 用最新盘口撮合挂单，成交价为挂单价
+func (book *PaperBook) onTicker(bid, ask float64) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	book.bid, book.ask = bid, ask
+	book.pruneClosed()
+	for _, order := range book.orders {
+		if order.Status == "closed" {
+			continue
+		}
+
+		filled := (order.Side == "buy" && ask > 0 && ask <= order.Price) ||
+			(order.Side == "sell" && bid > 0 && bid >= order.Price)
+		if !filled {
+			continue
+		}
+
+		qty := order.Size - order.FilledSize
+		order.FilledSize = order.Size
+		order.AvgFillPrice = order.Price
+		order.RemainingSize = 0
+		order.Status = "closed"
+		if order.Side == "buy" {
+			book.position += qty
+		} else {
+			book.position -= qty
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"clientId": order.ClientID,
+			"side":     order.Side,
+			"price":    order.Price,
+			"qty":      qty,
+			"position": book.position,
+		}).Infoln("PaperFill")
+		book.emit(order)
+	}
+}
+
+// 删除关闭超过保留时间的订单，调用时必须持有锁
+func (book *PaperBook) pruneClosed() {
+	for clientId, closedAt := range book.closedAt {
+		if time.Now().Sub(closedAt) > paperClosedRetention {
+			delete(book.orders, clientId)
+			delete(book.closedAt, clientId)
+		}
+	}
+}
+
+// 订单簿中的订单和持仓，用于保存状态，刚关闭的订单也保存以便重启后同步到成交
+func (book *PaperBook) snapshot() *PaperState {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	state := &PaperState{NextId: book.nextId, Position: book.position}
+	for _, order := range book.orders {
+		copied := *order
+		state.Orders = append(state.Orders, &copied)
+	}
+	return state
+}
+
+// 恢复保存的挂单和持仓
+func (book *PaperBook) restore(state *PaperState) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if state.NextId > book.nextId {
+		book.nextId = state.NextId
+	}
+	book.position = state.Position
+	for _, order := range state.Orders {
+		book.orders[order.ClientID] = order
+		if order.Status == "closed" {
+			book.closedAt[order.ClientID] = time.Now()
+		}
+	}
+}
+
+func (book *PaperBook) getOrders(market string) []*Order {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var orders []*Order
+	for _, order := range book.orders {
+		if order.Status != "closed" && order.Market == market {
+			copied := *order
+			orders = append(orders, &copied)
+		}
+	}
+	return orders
+}
+
+func (book *PaperBook) getOrderByClient(clientId string) (*Order, error) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	order, found := book.orders[clientId]
+	if !found {
+		return nil, fmt.Errorf("Order not found")
+	}
+	copied := *order
+	return &copied, nil
+}
+
+// 撤销全部挂单
+func (book *PaperBook) cancelAll() {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	for _, order := range book.orders {
+		if order.Status == "closed" {
+			continue
+		}
+		order.Status = "closed"
+		order.RemainingSize = 0
+		book.emit(order)
+	}
+}
+
+// 模拟平仓，直接减少持仓
+func (book *PaperBook) reducePosition(size float64) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.position -= size
+}
+
+func (book *PaperBook) getPosition() float64 {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	return book.position
+}
+
+// 查询挂单，模拟盘模式下从本地订单簿读取
+func fetchOpenOrders(market string) ([]*Order, error) {
+	if paperBook != nil {
+		return paperBook.getOrders(market), nil
+	}
+	return client.getOrders(market)
+}
+
+func fetchOrderByClient(clientId string) (*Order, error) {
+	if paperBook != nil {
+		return paperBook.getOrderByClient(clientId)
+	}
+	return client.getOrderByClient(clientId)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 下单队列已满，订单直接按下单失败处理
+var errPlaceQueueFull = errors.New("place queue full")
+
+// 请求失败或返回无法解析，交易所可能已经接受订单，由订单同步按客户端订单号确认
+var errPlaceUnknown = errors.New("place result unknown")
+
+// 紧急停止或退出后队列中的订单不再发送，按下单失败处理
+var errPlaceBlocked = errors.New("place blocked by halt or shutdown")
+
+var (
+	placeWorkers    = 4
+	placeQueueDepth = 50
+
+	placer *PlacePipeline
+)
+
+// 一个下单请求
+type PlaceRequest struct {
+	ClientId string
+	Market   string
+	Side     string
+	Price    float64
+	Type     string
+	Size     float64
+	Reduce   bool
+	Post     bool
+	QueuedAt time.Time
+}
+
+// 下单结果，Err 不为空时订单未被交易所接受
+type EventPlaceResult struct {
+	Request *PlaceRequest
+	Order   *Order
+	Err     error
+}
+
+// 下单流水线：并发数有上限，同一个网格的订单进入同一个队列，按提交顺序发送
+type PlacePipeline struct {
+	queues []chan *PlaceRequest
+	emit   func(result *EventPlaceResult)
+
+	// 非 0 时不再发送订单，工作协程在发送前检查
+	blocked int32
+	// 已提交但尚未执行完的下单请求
+	pending sync.WaitGroup
+}
+
+// workers 为 0 时在提交时同步下单，用于回测
+func NewPlacePipeline(workers, depth int, emit func(result *EventPlaceResult)) *PlacePipeline {
+	pipeline := &PlacePipeline{
+		queues: make([]chan *PlaceRequest, workers),
+		emit:   emit,
+	}
+	for i := range pipeline.queues {
+		queue := make(chan *PlaceRequest, depth)
+		pipeline.queues[i] = queue
+		go func() {
+			for req := range queue {
+				pipeline.execute(req)
+				inflight.Done()
+			}
+		}()
+	}
+	return pipeline
+}
+
+func (pipeline *PlacePipeline) execute(req *PlaceRequest) {
+	defer pipeline.pending.Done()
+	if atomic.LoadInt32(&pipeline.blocked) != 0 {
+		pipeline.emit(&EventPlaceResult{Request: req, Err: errPlaceBlocked})
+		return
+	}
+
+	wait := time.Now().Sub(req.QueuedAt)
+	order, err := place(req)
+	if wait > time.Second {
+		logrus.WithField("clientId", req.ClientId).WithField("wait", wait).Warnln("PlaceQueueSlow")
+	}
+	pipeline.emit(&EventPlaceResult{Request: req, Order: order, Err: err})
+}
+
+// 提交下单请求，key 相同的请求按顺序执行，队列满时返回错误
+func (pipeline *PlacePipeline) submit(key string, req *PlaceRequest) error {
+	req.QueuedAt = time.Now()
+	pipeline.pending.Add(1)
+	if len(pipeline.queues) == 0 {
+		pipeline.execute(req)
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	queue := pipeline.queues[h.Sum32()%uint32(len(pipeline.queues))]
+
+	inflight.Add(1)
+	select {
+	case queue <- req:
+		return nil
+	default:
+		inflight.Done()
+		pipeline.pending.Done()
+		return errPlaceQueueFull
+	}
+}
+
+// 停止发送订单，已经发出的请求不受影响，等待其完成后再撤单才能保证没有遗留的挂单
+func (pipeline *PlacePipeline) block() {
+	atomic.StoreInt32(&pipeline.blocked, 1)
+}
+
+func (pipeline *PlacePipeline) unblock() {
+	atomic.StoreInt32(&pipeline.blocked, 0)
+}
+
+// 等待已提交的下单请求执行完，超时返回 false
+func (pipeline *PlacePipeline) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pipeline.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// 发送订单到交易所或模拟盘
+func place(req *PlaceRequest) (*Order, error) {
+	log.Infoln("PlaceOrder", req.ClientId, req.Market, req.Side, req.Price, req.Type, req.Size, "reduce", req.Reduce, "postonly", req.Post)
+	if paperBook != nil {
+		if err := paperBook.place(req.ClientId, req.Market, req.Side, req.Price, req.Type, req.Size, req.Reduce, req.Post); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	resp, err := client.placeOrder(req.ClientId, req.Market, req.Side, req.Price, req.Type, req.Size, req.Reduce, req.Post)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPlaceUnknown, err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	log.Infoln("PlaceResult", string(b))
+
+	var result Result
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPlaceUnknown, err)
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	order := &Order{}
+	if err := json.Unmarshal(result.Result, order); err != nil {
+		return nil, nil
+	}
+	return order, nil
+}
+
+// 主循环中处理下单结果
+func onPlaceResult(event *EventPlaceResult) {
+	req := event.Request
+	if errors.Is(event.Err, errPlaceUnknown) {
+		log.WithError(event.Err).WithField("clientId", req.ClientId).Warnln("PlaceResultUnknown")
+		if gridOrder, found := orderMap.get(req.ClientId); found && gridOrder.State == OrderPendingNew {
+			gridOrder.transit(OrderUnknown, event.Err.Error())
+		}
+		return
+	}
+	if errors.Is(event.Err, errPlaceBlocked) {
+		log.WithField("clientId", req.ClientId).Infoln("PlaceBlocked")
+		onRejectOrder(req.ClientId, event.Err.Error())
+		return
+	}
+	if event.Err != nil {
+		log.WithError(event.Err).WithField("clientId", req.ClientId).Errorln("PlaceError")
+		onRejectOrder(req.ClientId, event.Err.Error())
+		// 余额不足暂停该方向下单，不逐笔通知
+		if isInsufficientBalance(event.Err) {
+			onInsufficientBalance(req)
+			return
+		}
+		if paperBook == nil {
+			SendDingTalkAsync(fmt.Sprintln("发送订单失败:", req.Market, req.Side, req.Price, req.Type, req.Size, req.Reduce, "原因：", event.Err))
+		}
+		return
+	}
+
+	gridOrder, found := orderMap.get(req.ClientId)
+	if !found {
+		return
+	}
+	if event.Order != nil && gridOrder.Id == 0 {
+		gridOrder.Id = event.Order.ID
+	}
+	if gridOrder.State == OrderPendingNew {
+		gridOrder.transit(OrderOpen, "accepted")
+	}
+}
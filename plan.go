@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// 最坏持仓超过该数量时启动前需要确认，0 表示不需要确认
+var confirmPosition float64
+
+// 当前实际持仓，模拟盘读取本地订单簿
+func currentPosition() (float64, error) {
+	if paperBook != nil {
+		return paperBook.getPosition(), nil
+	}
+	if isSpot() {
+		return spotPosition()
+	}
+
+	positions, err := client.getPositionsEx()
+	if err != nil {
+		return 0, err
+	}
+	for _, pos := range positions {
+		if pos.Future == perpName {
+			return pos.NetSize, nil
+		}
+	}
+	return 0, nil
+}
+
+// 启动前打印首个周期将执行的撤单和下单，以及全部开仓单成交后多空两个方向的最坏持仓
+// 超过 confirmPosition 时需要确认或使用 -yes 跳过
+func confirmStartupPlan() bool {
+	perp, err := fetchTicker()
+	if err != nil {
+		log.Errorln("fetchTicker:", err)
+		return false
+	}
+
+	position, err := currentPosition()
+	if err != nil {
+		log.Errorln("currentPosition:", err)
+		return false
+	}
+
+	plan := planOrders(perp.Bid, perp.Ask, marketMeta)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "bid=%v\task=%v\t\t\t\n", perp.Bid, perp.Ask)
+	fmt.Fprintln(w, "ACTION\tSIDE\tPRICE\tQTY\tGRID\t")
+	var plannedBuy, plannedSell float64
+	// 合并网格暂缓的撤单和离盘口太远的撤单
+	printed := map[*GridOrder]bool{}
+	for _, order := range append(heldCancels, plan.Cancels...) {
+		if printed[order] {
+			continue
+		}
+		printed[order] = true
+		fmt.Fprintf(w, "cancel\t%s\t%v\t%v\t%v-%v\t\n", order.Side,
+			orderPrice(order), order.Qty-order.EQty, order.Grid.OpenAt, order.Grid.CloseAt)
+	}
+	for _, order := range plan.Places {
+		if order.Grid.isOpenSide(order.Side) {
+			if order.Side == "buy" {
+				plannedBuy += order.Qty
+			} else {
+				plannedSell += order.Qty
+			}
+		}
+		fmt.Fprintf(w, "place\t%s\t%v\t%v\t%v-%v\t\n", order.Side,
+			order.Price, order.Qty, order.Grid.OpenAt, order.Grid.CloseAt)
+	}
+	w.Flush()
+
+	restingBuy, restingSell := restingOpenQty("buy"), restingOpenQty("sell")
+	worstLong := position + restingBuy + plannedBuy
+	worstShort := position - restingSell - plannedSell
+	fmt.Printf("position=%v restingBuy=%v plannedBuy=%v worstLong=%v restingSell=%v plannedSell=%v worstShort=%v\n",
+		position, restingBuy, plannedBuy, worstLong, restingSell, plannedSell, worstShort)
+
+	worst := math.Max(worstLong, -worstShort)
+	fmt.Printf("worstCase=%v notional=%v\n", worst, worst*perp.Bid)
+
+	if confirmPosition <= 0 || worst <= confirmPosition || autoConfirm {
+		return true
+	}
+
+	return prompt(fmt.Sprintf("最坏持仓 %v 超过 %v，输入 yes 继续: ", worst, confirmPosition)) == "yes"
+}
+
+func orderPrice(order *GridOrder) float64 {
+	if order.Grid.isOpenSide(order.Side) {
+		return order.Grid.OpenAt
+	}
+	return order.Grid.closePrice()
+}
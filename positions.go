@@ -7,22 +7,60 @@ import (
 	"time"
 )
 
-func monitorPosition() {
-	reportAccount()
-	for {
-		time.Sleep(time.Second)
+var (
+	// 是否在成交后发送持仓报告
+	positionReport = false
+	// 成交后等待该时间合并多笔成交再报告
+	positionReportWindow = time.Second * 10
 
-		if time.Now().Sub(lastPlaceTime) < time.Second*15 || time.Now().Sub(lastPlaceTime) > time.Second*20 {
-			continue
-		}
+	pendingFills   []*FilledLevel
+	firstPendingAt time.Time
+)
+
+// 一次网格成交
+type FilledLevel struct {
+	Time    time.Time
+	Side    string
+	OpenAt  float64
+	CloseAt float64
+	Price   float64
+	Qty     float64
+}
+
+// 记录订单流中的成交，由 onOrderChange 调用
+func recordFill(grid *TradeGrid, side string, price, qty float64) {
+	if !positionReport {
+		return
+	}
 
-		reportAccount()
+	if len(pendingFills) == 0 {
+		firstPendingAt = time.Now()
+	}
+	pendingFills = append(pendingFills, &FilledLevel{
+		Time:    time.Now(),
+		Side:    side,
+		OpenAt:  grid.OpenAt,
+		CloseAt: grid.CloseAt,
+		Price:   price,
+		Qty:     qty,
+	})
+}
 
-		time.Sleep(time.Second * 6)
+// 合并窗口结束后异步发送持仓报告
+func checkPositionReport() {
+	if len(pendingFills) == 0 || time.Now().Sub(firstPendingAt) < positionReportWindow {
+		return
 	}
+
+	fills := pendingFills
+	pendingFills = nil
+	profit := takeProfitSnapshot()
+	runAsync(func() {
+		reportAccount(fills, profit)
+	})
 }
 
-func reportAccount() {
+func reportAccount(fills []*FilledLevel, profit *ProfitSnapshot) {
 	accountInfo, err := client.getAccount()
 	if err != nil {
 		log.WithError(err).Errorln("getAccount")
@@ -38,14 +76,53 @@ func reportAccount() {
 		log.WithError(err).Errorln("getPositionsEx")
 	}
 
-	sendDingAccount(accountInfo, positions)
+	// 现货持仓为钱包余额
+	var balance *Balance
+	if isSpot() {
+		if balance, err = spotBalance(); err != nil {
+			log.WithError(err).Errorln("getBalances")
+		}
+	}
+
+	sendDingAccount(accountInfo, positions, balance, fills, profit)
+}
+
+// 主循环中的收益统计快照，供其他协程发送报告
+type ProfitSnapshot struct {
+	ProfitTotal     float64
+	FeeTotal        float64
+	FundingPaid     float64
+	FundingRate     float64
+	NextFundingRate float64
+	NetProfit       float64
 }
 
-func sendDingAccount(accountInfo *AccountInfo, positions []Position) {
+func takeProfitSnapshot() *ProfitSnapshot {
+	return &ProfitSnapshot{
+		ProfitTotal:     profitTotal,
+		FeeTotal:        feeTotal,
+		FundingPaid:     fundingPaid,
+		FundingRate:     fundingRate,
+		NextFundingRate: nextFundingRate,
+		NetProfit:       netProfitTotal(),
+	}
+}
+
+func sendDingAccount(accountInfo *AccountInfo, positions []Position, balance *Balance, fills []*FilledLevel, profit *ProfitSnapshot) {
 	buf := bytes.NewBuffer(nil)
 	fmt.Fprintln(buf, "【持仓告警】")
+	if len(fills) > 0 {
+		fmt.Fprintln(buf, "成交档位：")
+		for _, fill := range fills {
+			fmt.Fprintf(buf, "     - %s %-4v %v@%v 网格[%v,%v]\n", fill.Time.Format("15:04:05"),
+				fill.Side, fill.Qty, fill.Price, fill.OpenAt, fill.CloseAt)
+		}
+	}
 	fmt.Fprintln(buf, "资产总额：", accountInfo.Collateral)
 	fmt.Fprintln(buf, "可用资产：", accountInfo.FreeCollateral)
+	if balance != nil {
+		fmt.Fprintln(buf, "现货余额：", balance.Coin, balance.Total, "可用：", balance.Free)
+	}
 	fmt.Fprintln(buf, "持仓列表：")
 	if len(positions) == 0 {
 		for _, pos := range accountInfo.Positions {
@@ -70,9 +147,13 @@ func sendDingAccount(accountInfo *AccountInfo, positions []Position) {
 			}
 		}
 		if perpPrice != 0 && futuPrice != 0 {
-			fmt.Fprintf(buf, "期现价差：%v", 100*(futuPrice-perpPrice)/perpPrice)
+			fmt.Fprintf(buf, "期现价差：%v\n", 100*(futuPrice-perpPrice)/perpPrice)
 		}
 	}
+	fmt.Fprintln(buf, "网格利润：", profit.ProfitTotal)
+	fmt.Fprintln(buf, "手续费：", profit.FeeTotal)
+	fmt.Fprintln(buf, "资金费：", -profit.FundingPaid, "当前费率：", profit.FundingRate, "预测费率：", profit.NextFundingRate)
+	fmt.Fprintln(buf, "净利润：", profit.NetProfit)
 
-	SendDingtalkText(ding, buf.String())
+	SendDingtalkText(dingUrl(), buf.String())
 }
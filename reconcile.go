@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	reconcileInterval = time.Minute
+	// 网格之外的持仓，例如手动持有的底仓
+	positionOffset float64
+	// 实际持仓与网格持仓差异超过该数量视为偏差
+	driftTolerance float64
+	// alert 只告警，correct 同时用只减仓订单减掉多出的持仓并撤销遗留的机器人订单
+	reconcileAction = "alert"
+
+	lastReconcileTime time.Time
+	reconcilePending  bool
+
+	// 连续两次对账都有偏差才处理，避免成交推送延迟造成误报
+	driftCount   int
+	driftAlerted bool
+	// 已经告警过的未知订单
+	unknownAlerted = map[int64]bool{}
+	// 最近一次对账时实际持仓与预期持仓的差
+	reconcileDrift float64
+)
+
+// 预期的实际净持仓：网格持仓加上网格之外的底仓和手动成交
+func expectedPosition() float64 {
+	return gridExpectedPosition() + positionOffset + foreignPosition
+}
+
+// 推算的实际净持仓：最近一次对账的实际持仓加上之后的网格成交
+func estimatedPosition() float64 {
+	return expectedPosition() + reconcileDrift
+}
+
+// 对账时交易所的持仓和挂单
+type EventReconcile struct {
+	Position float64
+	Orders   []*Order
+	Err      error
+}
+
+// 定时从交易所获取持仓和挂单，在主循环中与网格状态对比
+func checkReconcile() {
+	if reconcilePending || halted || stopping || time.Now().Sub(lastReconcileTime) < reconcileInterval {
+		return
+	}
+	lastReconcileTime = time.Now()
+	reconcilePending = true
+
+	runAsync(func() {
+		event := &EventReconcile{}
+		defer func() {
+			eventChan <- event
+		}()
+
+		event.Position, event.Err = currentPosition()
+		if event.Err != nil {
+			return
+		}
+		event.Orders, event.Err = fetchOpenOrders(perpName)
+	})
+}
+
+func onReconcile(event *EventReconcile) {
+	reconcilePending = false
+	if event.Err != nil {
+		logrus.WithError(event.Err).Errorln("Reconcile")
+		return
+	}
+
+	expected := expectedPosition()
+	drift := event.Position - expected
+	reconcileDrift = drift
+	log.WithFields(logrus.Fields{
+		"position": event.Position,
+		"expected": expected,
+		"drift":    drift,
+	}).Infoln("Reconcile")
+
+	if math.Abs(drift) <= driftTolerance+1e-9 {
+		if driftAlerted {
+			SendDingTalkAsync(fmt.Sprintln("持仓偏差已恢复:", perpName, "实际持仓：", event.Position, "网格持仓：", expected))
+		}
+		driftCount, driftAlerted = 0, false
+	} else {
+		driftCount++
+		if driftCount >= 2 {
+			onPositionDrift(event.Position, expected, drift)
+		}
+	}
+
+	// 带机器人前缀但不在订单表中的是遗留订单，其他的是手动订单
+	var unknown []*Order
+	for _, order := range event.Orders {
+		if _, found := orderMap.get(order.ClientID); found {
+			continue
+		}
+		if isBotOrder(order.ClientID) {
+			unknown = append(unknown, order)
+		} else {
+			onForeignOrder(order)
+		}
+	}
+	onUnknownOrders(unknown)
+	pruneForeignOrders()
+}
+
+func onPositionDrift(position, expected, drift float64) {
+	if !driftAlerted {
+		driftAlerted = true
+		SendDingTalkAsync(fmt.Sprintln("持仓偏差:", perpName, "实际持仓：", position, "网格持仓：", expected,
+			"偏差：", drift, "容忍：", driftTolerance, "处理：", reconcileAction))
+	}
+
+	// 只修正多出的持仓，持仓不足时无法判断属于哪个网格，只告警
+	if reconcileAction != "correct" || math.Abs(position) < math.Abs(expected) || position*drift <= 0 {
+		return
+	}
+
+	slice := flattenSlice
+	runAsync(func() {
+		if err := reduceExcess(drift, slice); err != nil {
+			logrus.WithError(err).Errorln("CorrectDrift")
+		}
+	})
+}
+
+// 用一笔只减仓 IOC 订单减掉多出的持仓，excess 为正时卖出
+func reduceExcess(excess, slice float64) error {
+	perp, err := fetchTicker()
+	if err != nil {
+		return err
+	}
+
+	size := math.Abs(excess)
+	if slice > 0 && size > slice {
+		size = slice
+	}
+	size = roundTo(size, perp.SizeIncrement)
+	if size < perp.SizeIncrement {
+		return nil
+	}
+
+	// 价格让出 1% 保证成交
+	side, price := "sell", perp.Bid*0.99
+	if excess < 0 {
+		side, price = "buy", perp.Ask*1.01
+	}
+	price = roundTo(price, perp.PriceIncrement)
+
+	log.WithFields(logrus.Fields{
+		"side":  side,
+		"price": price,
+		"size":  size,
+	}).Warnln("CorrectDrift")
+
+	if paperBook != nil {
+		if side == "buy" {
+			size = -size
+		}
+		paperBook.reducePosition(size)
+		return nil
+	}
+
+	_, err = client.placeIocOrder(newTakerClientId(), perpName, side, price, size, !isSpot())
+	return err
+}
+
+func onUnknownOrders(orders []*Order) {
+	var fresh []*Order
+	seen := map[int64]bool{}
+	for _, order := range orders {
+		seen[order.ID] = true
+		if !unknownAlerted[order.ID] {
+			fresh = append(fresh, order)
+		}
+	}
+	// 已经关闭的未知订单不再跟踪
+	for id := range unknownAlerted {
+		if !seen[id] {
+			delete(unknownAlerted, id)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	msg := fmt.Sprintln("发现遗留挂单:", perpName, "数量：", len(fresh), "处理：", reconcileAction)
+	for _, order := range fresh {
+		unknownAlerted[order.ID] = true
+		msg += fmt.Sprintln(order.ID, order.ClientID, order.Side, order.Price, order.Size)
+		log.WithFields(logrus.Fields{
+			"id":       order.ID,
+			"clientId": order.ClientID,
+			"side":     order.Side,
+			"price":    order.Price,
+			"size":     order.Size,
+		}).Warnln("UnknownOrder")
+	}
+	SendDingTalkAsync(msg)
+
+	if reconcileAction != "correct" {
+		return
+	}
+	for _, order := range fresh {
+		clientId, id := order.ClientID, order.ID
+		runAsync(func() {
+			sendCancel(clientId, id, 1)
+		})
+	}
+}
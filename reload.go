@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 热加载网格文件和基本配置
+type EventReload struct {
+	Reason string
+}
+
+var (
+	gridFileModTime time.Time
+	cfgFileModTime  time.Time
+)
+
+// 收到 SIGHUP 时触发热加载
+func watchReloadSignal(eventChan chan interface{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			eventChan <- &EventReload{Reason: "SIGHUP"}
+		}
+	}()
+}
+
+func fileModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// 记录配置文件当前的修改时间，作为变更检测的起点
+func markConfigFiles() {
+	gridFileModTime = fileModTime(*gridFile)
+	cfgFileModTime = fileModTime(*cfgFile)
+}
+
+// 在主循环中调用，文件修改时间变化时热加载
+func checkConfigFiles() {
+	if *gridFile != "" && fileModTime(*gridFile).After(gridFileModTime) {
+		reload("grid file changed")
+		return
+	}
+	if *cfgFile != "" && fileModTime(*cfgFile).After(cfgFileModTime) {
+		reload("config file changed")
+	}
+}
+
+// 重新加载基本配置和网格，websocket 连接不受影响
+func reload(reason string) {
+	log.WithField("reason", reason).Infoln("Reload")
+	gridChanged := *gridFile != "" && fileModTime(*gridFile).After(gridFileModTime)
+	markConfigFiles()
+
+	if *cfgFile != "" {
+		if config, err := readBaseConfig(*cfgFile); err != nil {
+			logrus.WithError(err).Errorln("ReloadConfig")
+		} else {
+			if config.SubAccount != subAccount ||
+				(config.Keystore == "" && (config.ApiKey != apiKey || config.SecretKey != secretKey)) {
+				log.Warnln("ReloadConfig: credential changes require restart")
+			}
+			if changed := restartRequired(config); len(changed) > 0 {
+				log.WithField("fields", changed).Warnln("ReloadConfig: changes require restart")
+				SendDingTalkAsync(fmt.Sprintln("以下配置修改需要重启后生效:", myName, strings.Join(changed, ", ")))
+			}
+			applyBaseConfig(config)
+			nextReportAt = time.Time{}
+		}
+	}
+
+	if foreignPaused {
+		foreignPaused = false
+		log.Infoln("ForeignResume")
+	}
+
+	// 跟踪网格只在网格文件修改时合并，避免覆盖平移后的网格
+	if *gridFile != "" && (trailMode == "" || gridChanged) {
+		if err := mergeGridFile(*gridFile); err != nil {
+			logrus.WithError(err).Errorln("MergeGridFile")
+			SendDingTalkAsync(fmt.Sprintln("网格热加载失败:", err))
+		} else {
+			persistGrids()
+			writeGridCurrent()
+		}
+	}
+}
+
+// 读取网格文件并与运行中的网格合并
+func mergeGridFile(file string) error {
+	perp, _, fileGrids, err := readGridFile(file)
+	if err != nil {
+		return err
+	}
+	if perp != perpName {
+		return fmt.Errorf("market changed from %s to %s", perpName, perp)
+	}
+	if err := checkGridFees(fileGrids); err != nil {
+		return err
+	}
+
+	report := mergeGrids(fileGrids)
+	report.print()
+	return nil
+}
+
+// 启动时合并网格产生的撤单，确认启动计划后才发送
+var (
+	holdCancels bool
+	heldCancels []*GridOrder
+)
+
+func releaseHeldCancels() {
+	holdCancels = false
+	for _, order := range heldCancels {
+		if _, found := orderMap.get(order.ClientId); found && !order.pendingCancel() {
+			cancelGridOrder(order)
+		}
+	}
+	heldCancels = nil
+}
+
+type gridLevel struct {
+	OpenAt  float64
+	CloseAt float64
+}
+
+func levelOf(grid *TradeGrid) gridLevel {
+	return gridLevel{OpenAt: grid.OpenAt, CloseAt: grid.CloseAt}
+}
+
+type MovedGrid struct {
+	Grid *TradeGrid
+	From gridLevel
+}
+
+type MergeReport struct {
+	Moved   []*MovedGrid
+	Kept    []*TradeGrid
+	Added   []*TradeGrid
+	Retired []*TradeGrid
+	Dropped []*TradeGrid
+}
+
+func (report *MergeReport) print() {
+	for _, moved := range report.Moved {
+		log.Infof("MergeMove    %v-%v -> %v-%v", moved.From.OpenAt, moved.From.CloseAt, moved.Grid.OpenAt, moved.Grid.CloseAt)
+	}
+	for _, grid := range report.Kept {
+		log.Infof("MergeKeep    %v-%v open=%v close=%v", grid.OpenAt, grid.CloseAt, grid.OpenChance, grid.CloseChance)
+	}
+	for _, grid := range report.Added {
+		log.Infof("MergeAdd     %v-%v open=%v close=%v", grid.OpenAt, grid.CloseAt, grid.OpenChance, grid.CloseChance)
+	}
+	for _, grid := range report.Retired {
+		log.Infof("MergeRetire  %v-%v close=%v", grid.OpenAt, grid.CloseAt, grid.CloseChance)
+	}
+	for _, grid := range report.Dropped {
+		log.Infof("MergeDrop    %v-%v", grid.OpenAt, grid.CloseAt)
+	}
+	log.Infof("Merge kept=%d moved=%d added=%d retired=%d dropped=%d",
+		len(report.Kept), len(report.Moved), len(report.Added), len(report.Retired), len(report.Dropped))
+}
+
+// 网格当前的额度，包括未下单的机会和挂单中未成交的数量，扣除尚未扣减的额度
+func gridCapacity(grid *TradeGrid) float64 {
+	capacity := grid.OpenChance + grid.CloseChance - grid.Shortfall
+	for _, order := range grid.OpenOrders.Orders {
+		capacity += order.Qty - order.EQty
+	}
+	for _, order := range grid.CloseOrders.Orders {
+		capacity += order.Qty - order.EQty
+	}
+	return capacity
+}
+
+// 数量回到开仓机会，先抵扣额度调低时未能扣除的部分
+func (grid *TradeGrid) restoreOpenChance(qty float64) {
+	if grid.Shortfall > 0 {
+		paid := math.Min(grid.Shortfall, qty)
+		grid.Shortfall -= paid
+		qty -= paid
+	}
+	grid.OpenChance += qty
+}
+
+// 网格持有的仓位数量
+func (grid *TradeGrid) inventory() float64 {
+	return grid.OpenTotal - grid.CloseTotal
+}
+
+// 退役网格仍持有的仓位占用继任网格的额度
+func heldBySuccessor() map[string]float64 {
+	held := map[string]float64{}
+	for _, grid := range grids {
+		if grid.Retired && grid.Successor != "" {
+			held[grid.Successor] += grid.inventory()
+		}
+	}
+	return held
+}
+
+func findGrid(uuid string) *TradeGrid {
+	for _, grid := range grids {
+		if grid.Uuid == uuid {
+			return grid
+		}
+	}
+	return nil
+}
+
+// 合并新网格：优先按 Uuid 匹配，其次按价格档位匹配。匹配的网格保留运行状态并按新额度调整开仓机会，
+// 新增档位直接加入，移除的档位撤销开仓单后只平仓，平仓完成后删除。
+// 按 Uuid 匹配但价格变化的网格没有持仓时直接移动，有持仓时退役并新增网格，持仓按原价格平仓，
+// 平仓前占用新网格的额度，被移除档位的持仓占用同方向的其他网格，避免总持仓超过网格文件的额度
+func mergeGrids(fileGrids []*TradeGrid) *MergeReport {
+	report := &MergeReport{}
+
+	byUuid := map[string]*TradeGrid{}
+	byLevel := map[gridLevel]*TradeGrid{}
+	for _, grid := range grids {
+		byUuid[grid.Uuid] = grid
+		byLevel[levelOf(grid)] = grid
+	}
+	matched := map[*TradeGrid]bool{}
+	capacity := map[*TradeGrid]float64{}
+	successors := map[*TradeGrid]*TradeGrid{}
+
+	merged := make([]*TradeGrid, 0, len(fileGrids))
+	for _, fileGrid := range fileGrids {
+		grid, found := byUuid[fileGrid.Uuid]
+		if !found || matched[grid] {
+			grid, found = byLevel[levelOf(fileGrid)]
+		}
+		// 方向改变的网格不能沿用持仓，按新增处理，原网格退役
+		if !found || matched[grid] || grid.short() != fileGrid.short() {
+			merged = append(merged, fileGrid)
+			report.Added = append(report.Added, fileGrid)
+			continue
+		}
+
+		if levelOf(grid) != levelOf(fileGrid) {
+			// 持仓按原开仓价买入，不能按新价格平仓，原网格退役由新网格接替
+			if grid.inventory() > 0 {
+				successors[grid] = fileGrid
+				merged = append(merged, fileGrid)
+				report.Added = append(report.Added, fileGrid)
+				continue
+			}
+
+			// 没有持仓时撤销挂单，未成交部分回到机会后按新价格重新挂单
+			report.Moved = append(report.Moved, &MovedGrid{Grid: grid, From: levelOf(grid)})
+			grid.OpenAt, grid.CloseAt = fileGrid.OpenAt, fileGrid.CloseAt
+			// 按波动率调整的平仓价属于原档位，波动率样本就绪后按新档位重新计算
+			grid.ExitAt = 0
+			for _, order := range grid.OpenOrders.Orders {
+				if !order.pendingCancel() {
+					cancelGridOrder(order)
+				}
+			}
+			for _, order := range grid.CloseOrders.Orders {
+				if !order.pendingCancel() {
+					cancelGridOrder(order)
+				}
+			}
+		}
+
+		matched[grid] = true
+		grid.Retired = false
+		grid.Successor = ""
+		capacity[grid] = fileGrid.OpenChance + fileGrid.CloseChance
+		merged = append(merged, grid)
+		report.Kept = append(report.Kept, grid)
+	}
+
+	for _, grid := range grids {
+		if matched[grid] {
+			continue
+		}
+		if !grid.Retired {
+			retireGrid(grid)
+			report.Retired = append(report.Retired, grid)
+		}
+		// 接替的网格沿用原 Uuid，退役网格换新 Uuid
+		if successor, found := successors[grid]; found {
+			grid.Uuid = newGridUuid()
+			grid.Successor = successor.Uuid
+		}
+		merged = append(merged, grid)
+	}
+
+	grids = merged
+	report.Dropped = dropRetiredGrids()
+
+	// 额度变化只调整开仓机会，已有持仓不受影响，退役网格仍持有的仓位从继任网格的开仓机会中扣除
+	held := heldBySuccessor()
+	for _, grid := range report.Kept {
+		grid.OpenChance += capacity[grid] - gridCapacity(grid)
+		grid.Shortfall = 0
+	}
+	// 移除档位或平移网格后没有继任网格的持仓，由同方向剩余开仓机会最多的网格接替
+	for _, grid := range grids {
+		if !grid.Retired || grid.inventory() <= 0 {
+			continue
+		}
+		if successor := findGrid(grid.Successor); successor != nil && !successor.Retired {
+			continue
+		}
+		grid.Successor = ""
+		var best *TradeGrid
+		for _, candidate := range grids {
+			if candidate.Retired || candidate.short() != grid.short() {
+				continue
+			}
+			if best == nil || candidate.OpenChance-held[candidate.Uuid] > best.OpenChance-held[best.Uuid] {
+				best = candidate
+			}
+		}
+		if best != nil {
+			grid.Successor = best.Uuid
+			held[best.Uuid] += grid.inventory()
+		}
+	}
+	for _, grid := range grids {
+		if grid.Retired {
+			continue
+		}
+		grid.OpenChance -= held[grid.Uuid]
+		// 开仓机会不足以扣除时记下差额，持仓平仓或挂单撤销回到开仓机会时再扣除
+		if grid.OpenChance < 0 {
+			grid.Shortfall = -grid.OpenChance
+			grid.OpenChance = 0
+		}
+	}
+	return report
+}
+
+func retireGrid(grid *TradeGrid) {
+	grid.Retired = true
+	grid.OpenChance = 0
+	for _, order := range grid.OpenOrders.Orders {
+		if !order.pendingCancel() {
+			cancelGridOrder(order)
+		}
+	}
+}
+
+// 已删除网格的利润和手续费，重启时不能再从网格汇总，需要单独保存
+var droppedProfit, droppedFee float64
+
+// 删除已经完成平仓且没有挂单的退役网格
+func dropRetiredGrids() []*TradeGrid {
+	var dropped []*TradeGrid
+	kept := grids[:0]
+	for _, grid := range grids {
+		if grid.Retired && grid.CloseChance == 0 &&
+			len(grid.OpenOrders.Orders) == 0 && len(grid.CloseOrders.Orders) == 0 {
+			droppedProfit += grid.Profit
+			droppedFee += grid.FeeTotal
+			dropped = append(dropped, grid)
+			continue
+		}
+		kept = append(kept, grid)
+	}
+	grids = kept
+	return dropped
+}
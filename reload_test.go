@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+// 额度调低到持仓以下时，差额在平仓成交后从开仓机会中扣除，额度不会恢复到原来的数量
+func TestMergeShrinksBelowInventory(t *testing.T) {
+	saveFile = ""
+	orderAuditFile = ""
+	paperBook = nil
+	perpName = "TEST-PERP"
+	orderMap = NewOrderMap()
+
+	grid := &TradeGrid{
+		Uuid:        newGridUuid(),
+		OpenAt:      100,
+		CloseAt:     101,
+		CloseChance: 1,
+		OpenTotal:   1,
+		OpenOrders:  NewOrderMap(),
+		CloseOrders: NewOrderMap(),
+	}
+	grids = []*TradeGrid{grid}
+
+	mergeGrids([]*TradeGrid{{
+		Uuid:        grid.Uuid,
+		OpenAt:      100,
+		CloseAt:     101,
+		OpenChance:  0.5,
+		OpenOrders:  NewOrderMap(),
+		CloseOrders: NewOrderMap(),
+	}})
+	if grid.OpenChance != 0 || grid.CloseChance != 1 || grid.Shortfall != 0.5 {
+		t.Fatalf("after merge open=%v close=%v shortfall=%v", grid.OpenChance, grid.CloseChance, grid.Shortfall)
+	}
+	if capacity := gridCapacity(grid); capacity != 0.5 {
+		t.Fatalf("capacity after merge = %v, want 0.5", capacity)
+	}
+
+	// 平仓单全部成交
+	order := &GridOrder{ClientId: newClientId(), Qty: 1, Grid: grid, Side: "sell", State: OrderOpen}
+	grid.CloseChance = 0
+	grid.CloseOrders.add(order)
+	orderMap.add(order)
+	onOrderChange(&Order{ClientID: order.ClientId, Market: perpName, Side: "sell", Price: 101, Size: 1, FilledSize: 1, Status: "closed", PostOnly: true})
+
+	if grid.OpenChance != 0.5 || grid.Shortfall != 0 {
+		t.Fatalf("after close open=%v shortfall=%v, want 0.5 and 0", grid.OpenChance, grid.Shortfall)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 周期统计，每次发送报告后重置
+type ReportStats struct {
+	Since        time.Time
+	Fills        int
+	Volume       float64 // 成交额
+	Fees         float64
+	Profit       float64 // 网格已实现利润(未扣手续费)
+	FundingStart float64 // 周期开始时的累计资金费
+}
+
+var (
+	// 定时报告周期：daily、hourly，为空表示不发送
+	reportPeriod = ""
+	reportStats  = &ReportStats{Since: time.Now()}
+	nextReportAt time.Time
+)
+
+func (stats *ReportStats) addFill(qty, price, fee, profit float64) {
+	stats.Fills++
+	stats.Volume += qty * price
+	stats.Fees += fee
+	stats.Profit += profit
+}
+
+// 下一个报告时间点，按自然小时或自然日对齐
+func nextReportTime(now time.Time) time.Time {
+	switch reportPeriod {
+	case "hourly":
+		return now.Truncate(time.Hour).Add(time.Hour)
+	case "daily":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// 网格累计成交推算出的持仓
+func gridExpectedPosition() float64 {
+	var position float64
+	for _, grid := range grids {
+		position += grid.position()
+	}
+	return position
+}
+
+type ReportSnapshot struct {
+	MyName           string
+	Since            time.Time
+	Until            time.Time
+	Stats            ReportStats
+	FundingPeriod    float64
+	ProfitTotal      float64
+	FeeTotal         float64
+	FundingPaid      float64
+	NetProfit        float64
+	ExpectedPosition float64
+}
+
+// 在主循环中调用，到达报告时间时生成快照并异步发送
+func checkReport() {
+	if reportPeriod == "" {
+		return
+	}
+
+	now := time.Now()
+	if nextReportAt.IsZero() {
+		nextReportAt = nextReportTime(now)
+		return
+	}
+	if now.Before(nextReportAt) {
+		return
+	}
+	nextReportAt = nextReportTime(now)
+
+	snapshot := &ReportSnapshot{
+		MyName:           myName,
+		Since:            reportStats.Since,
+		Until:            now,
+		Stats:            *reportStats,
+		FundingPeriod:    fundingPaid - reportStats.FundingStart,
+		ProfitTotal:      profitTotal,
+		FeeTotal:         feeTotal,
+		FundingPaid:      fundingPaid,
+		NetProfit:        netProfitTotal(),
+		ExpectedPosition: expectedPosition(),
+	}
+	reportStats = &ReportStats{Since: now, FundingStart: fundingPaid}
+
+	runAsync(func() {
+		sendDingReport(snapshot)
+	})
+}
+
+func sendDingReport(snapshot *ReportSnapshot) {
+	var (
+		netSize    float64
+		unrealized float64
+	)
+	if isSpot() {
+		var err error
+		if netSize, err = spotPosition(); err != nil {
+			logrus.WithError(err).Errorln("getBalances")
+		}
+	} else {
+		positions, err := client.getPositionsEx()
+		if err != nil {
+			logrus.WithError(err).Errorln("getPositionsEx")
+		}
+		for _, pos := range positions {
+			if pos.Future == perpName {
+				netSize = pos.NetSize
+				unrealized = pos.UnrealizedPnl
+			}
+		}
+	}
+
+	stats := snapshot.Stats
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintln(buf, "【收益报告】", snapshot.MyName, perpName)
+	fmt.Fprintln(buf, "统计周期：", snapshot.Since.Format("01-02 15:04"), "~", snapshot.Until.Format("01-02 15:04"))
+	fmt.Fprintln(buf, "成交笔数：", stats.Fills)
+	fmt.Fprintln(buf, "成交金额：", stats.Volume)
+	fmt.Fprintln(buf, "网格利润：", stats.Profit, "累计：", snapshot.ProfitTotal)
+	fmt.Fprintln(buf, "手续费：", stats.Fees, "累计：", snapshot.FeeTotal)
+	fmt.Fprintln(buf, "资金费：", -snapshot.FundingPeriod, "累计：", -snapshot.FundingPaid)
+	fmt.Fprintln(buf, "净利润：", stats.Profit-stats.Fees-snapshot.FundingPeriod, "累计：", snapshot.NetProfit)
+	fmt.Fprintln(buf, "未实现盈亏：", unrealized)
+	fmt.Fprintln(buf, "实际持仓：", netSize, "预期持仓：", snapshot.ExpectedPosition,
+		"偏差：", netSize-snapshot.ExpectedPosition)
+
+	SendDingtalkText(dingUrl(), buf.String())
+}
@@ -27,24 +27,24 @@ type OrderParam struct {
 	Price      float64 `json:"price"`
 	Type       string  `json:"type"`
 	Size       float64 `json:"size"`
-	ReduceOnly bool    `json:"reduceOnly",omitempty`
-	Ioc        bool    `json:"ioc",omitempty`
-	PostOnly   bool    `json:"postOnly",omitempty`
+	ReduceOnly bool    `json:"reduceOnly,omitempty"`
+	Ioc        bool    `json:"ioc,omitempty"`
+	PostOnly   bool    `json:"postOnly,omitempty"`
 	ClientId   string  `json:"clientId,omitempty"`
 }
 
 type Market struct {
 	Name           string  `json:"name"`
 	Type           string  `json:"type"`
-	BaseCurrency   string  `json:"baseCurrency",omitempty`
-	quoteCurrency  string  `json:"quoteCurrency",omitempty`
+	BaseCurrency   string  `json:"baseCurrency,omitempty"`
+	QuoteCurrency  string  `json:"quoteCurrency,omitempty"`
 	Underlying     string  `json:"underlying"`
 	Enable         bool    `json:"enable"`
 	Ask            float64 `json:"ask"`
 	Bid            float64 `json:"bid"`
 	Last           float64 `json:"last"`
 	PriceIncrement float64 `json:"priceIncrement"`
-	sizeIncrement  float64 `json:"sizeIncrement"`
+	SizeIncrement  float64 `json:"sizeIncrement"`
 	Restricted     bool    `json:"restricted"`
 }
 
@@ -147,8 +147,18 @@ func (client *FtxClient) deleteOrder(orderId int64) (*http.Response, error) {
 	return client._delete(path, []byte(""))
 }
 
-func (client *FtxClient) deleteAllOrders() (*http.Response, error) {
-	return client._delete("orders", []byte(""))
+// 通过客户端订单号撤单，适用于尚未收到交易所订单号的订单
+func (client *FtxClient) deleteOrderByClient(clientId string) (*http.Response, error) {
+	return client._delete("orders/by_client_id/"+clientId, []byte(""))
+}
+
+// 撤销市场内全部订单，market 为空时撤销账户全部订单
+func (client *FtxClient) deleteAllOrders(market string) (*http.Response, error) {
+	body := []byte("")
+	if market != "" {
+		body, _ = json.Marshal(map[string]string{"market": market})
+	}
+	return client._delete("orders", body)
 }
 
 func (client *FtxClient) placeOrder(clientId string, market string, side string, price float64, _type string, size float64, reduce bool, post bool) (*http.Response, error) {
@@ -158,6 +168,19 @@ func (client *FtxClient) placeOrder(clientId string, market string, side string,
 	return resp, err
 }
 
+// 立即成交否则撤销的订单，用于紧急平仓，现货不支持只减仓
+func (client *FtxClient) placeIocOrder(clientId string, market string, side string, price float64, size float64, reduce bool) (*Order, error) {
+	newOrder := OrderParam{Market: market, Side: side, Price: price, Type: "limit", Size: size, ReduceOnly: reduce, Ioc: true, ClientId: clientId}
+	body, _ := json.Marshal(newOrder)
+	resp, err := client._post("orders", body)
+	var data Order
+	err = parseResultWrap(err, resp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
 func (client *FtxClient) getFutures() (*http.Response, error) {
 	return client._get("futures", []byte(""))
 }
@@ -231,25 +254,171 @@ func (client *FtxClient) getAccount() (*AccountInfo, error) {
 	return &accountInfo, nil
 }
 
+type Fill struct {
+	ID          int64     `json:"id"`
+	Market      string    `json:"market"`
+	Future      string    `json:"future"`
+	Side        string    `json:"side"`
+	Price       float64   `json:"price"`
+	Size        float64   `json:"size"`
+	Fee         float64   `json:"fee"`
+	FeeRate     float64   `json:"feeRate"`
+	FeeCurrency string    `json:"feeCurrency"`
+	Liquidity   string    `json:"liquidity"`
+	OrderID     int64     `json:"orderId"`
+	TradeID     int64     `json:"tradeId"`
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+}
+
+func (client *FtxClient) getFills(market string, start, end time.Time, limit int) ([]*Fill, error) {
+	path := fmt.Sprintf("fills?market=%s&start_time=%d&end_time=%d&limit=%d", market, start.Unix(), end.Unix(), limit)
+	rsp, err := client._get(path, []byte(""))
+	var data []*Fill
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (client *FtxClient) getOrderHistory(market string, start, end time.Time, limit int) ([]*Order, error) {
+	path := fmt.Sprintf("orders/history?market=%s&start_time=%d&end_time=%d&limit=%d", market, start.Unix(), end.Unix(), limit)
+	rsp, err := client._get(path, []byte(""))
+	var data []*Order
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type Candle struct {
+	Close     float64   `json:"close"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Open      float64   `json:"open"`
+	StartTime time.Time `json:"startTime"`
+	Volume    float64   `json:"volume"`
+}
+
+// resolution 单位为秒
+func (client *FtxClient) getCandles(market string, resolution int, start, end time.Time) ([]*Candle, error) {
+	path := fmt.Sprintf("markets/%s/candles?resolution=%d&start_time=%d&end_time=%d",
+		market, resolution, start.Unix(), end.Unix())
+	rsp, err := client._get(path, []byte(""))
+	var data []*Candle
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type FundingPayment struct {
+	Future  string    `json:"future"`
+	ID      int64     `json:"id"`
+	Payment float64   `json:"payment"` // 正数为支付，负数为收取
+	Rate    float64   `json:"rate"`
+	Time    time.Time `json:"time"`
+}
+
+func (client *FtxClient) getFundingPayments(future string, start time.Time) ([]FundingPayment, error) {
+	path := fmt.Sprintf("funding_payments?future=%s&start_time=%d", future, start.Unix())
+	rsp, err := client._get(path, []byte(""))
+	var data []FundingPayment
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type FundingRate struct {
+	Future string    `json:"future"`
+	Rate   float64   `json:"rate"`
+	Time   time.Time `json:"time"`
+}
+
+func (client *FtxClient) getFundingRates(future string) ([]FundingRate, error) {
+	rsp, err := client._get("funding_rates?future="+future, []byte(""))
+	var data []FundingRate
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type FutureStats struct {
+	Volume          float64   `json:"volume"`
+	NextFundingRate float64   `json:"nextFundingRate"`
+	NextFundingTime time.Time `json:"nextFundingTime"`
+	OpenInterest    float64   `json:"openInterest"`
+}
+
+func (client *FtxClient) getFutureStats(future string) (*FutureStats, error) {
+	rsp, err := client._get("futures/"+future+"/stats", []byte(""))
+	var data FutureStats
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
 type MarketItem struct {
 	Ask            float64 `json:"ask"`
 	Bid            float64 `json:"bid"`
 	Enabled        bool    `json:"enabled"`
 	Last           float64 `json:"last"`
 	Name           string  `json:"name"`
+	BaseCurrency   string  `json:"baseCurrency"`
+	QuoteCurrency  string  `json:"quoteCurrency"`
 	PriceIncrement float64 `json:"priceIncrement"`
 	Restricted     bool    `json:"restricted"`
 	SizeIncrement  float64 `json:"sizeIncrement"`
+	MinProvideSize float64 `json:"minProvideSize"`
 	Type           string  `json:"type"`
 	Underlying     string  `json:"underlying"`
 }
 
+// 单个市场的行情和交易单位，现货和合约都适用
+func (client *FtxClient) getMarket(market string) (*MarketItem, error) {
+	rsp, err := client._get("markets/"+market, []byte(""))
+	var data MarketItem
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+type Balance struct {
+	Coin     string  `json:"coin"`
+	Free     float64 `json:"free"`
+	Total    float64 `json:"total"`
+	UsdValue float64 `json:"usdValue"`
+}
+
+func (client *FtxClient) getBalances() ([]Balance, error) {
+	rsp, err := client._get("wallet/balances", []byte(""))
+	var data []Balance
+	err = parseResultWrap(err, rsp, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 type FuturesItem struct {
 	Ask            float64 `json:"ask"`
 	Bid            float64 `json:"bid"`
 	Name           string  `json:"name"`
 	PriceIncrement float64 `json:"priceIncrement"`
 	SizeIncrement  float64 `json:"sizeIncrement"`
+	LowerBound     float64 `json:"lowerBound"`
+	UpperBound     float64 `json:"upperBound"`
 	Type           string  `json:"type"`
 	Underlying     string  `json:"underlying"`
 }
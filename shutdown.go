@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+type EventShutdown struct {
+	Signal os.Signal
+}
+
+var (
+	// 正在退出，不再提交新订单
+	stopping bool
+	// 退出时是否撤销挂单
+	cancelOnExit bool
+	// 退出时等待撤单确认和请求完成的最长时间
+	shutdownTimeout = time.Second * 10
+
+	// 进行中的交易所请求
+	inflight sync.WaitGroup
+
+	wsMutex   sync.Mutex
+	wsCurrent *WebsocketClient
+	wsStopped bool
+)
+
+// 第一次信号优雅退出，第二次信号立即退出
+func watchShutdownSignal(eventChan chan interface{}) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		eventChan <- &EventShutdown{Signal: sig}
+		sig = <-sigs
+		log.WithField("signal", sig).Warnln("ForceExit")
+		os.Exit(1)
+	}()
+}
+
+// 维持订单推送连接，断开后自动重连，退出时不再重连
+func runWebsocket(eventChan chan interface{}) {
+	for {
+		wsclient := &WebsocketClient{
+			apiKey:     apiKey,
+			secret:     []byte(secretKey),
+			subAccount: subAccount,
+			quit:       make(chan interface{}),
+		}
+		wsclient.onOrderChange = func(body []byte) {
+			order := &Order{}
+			raw := gjson.GetBytes(body, "data").Raw
+			json.Unmarshal([]byte(raw), &order)
+			// 没有客户端订单号的是网页或其他工具下的手动订单，只处理本市场的
+			if order.ClientID == "" && order.Market != perpName {
+				return
+			}
+			eventChan <- order
+		}
+
+		if err := wsclient.dial(false); err != nil {
+			logrus.WithError(err).Errorln("DialWebsocketFailed")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		wsMutex.Lock()
+		if wsStopped {
+			wsMutex.Unlock()
+			wsclient.shutdown()
+			return
+		}
+		wsCurrent = wsclient
+		wsMutex.Unlock()
+
+		wsclient.ping()
+		wsclient.login()
+		wsclient.subOrder()
+
+		wsclient.waitFinished()
+
+		wsMutex.Lock()
+		stopped := wsStopped
+		wsMutex.Unlock()
+		if stopped {
+			return
+		}
+		logrus.Errorln("WebsocketStop")
+		time.Sleep(time.Second)
+	}
+}
+
+func stopWebsocket() {
+	wsMutex.Lock()
+	defer wsMutex.Unlock()
+	wsStopped = true
+	if wsCurrent != nil {
+		wsCurrent.shutdown()
+	}
+}
+
+// 停止下单并丢弃队列中的订单，等待已经发出的请求完成后按配置撤销挂单，处理剩余事件后保存状态并关闭连接
+func gracefulShutdown(eventChan chan interface{}, sig os.Signal) {
+	log.WithField("signal", sig).WithField("cancelOnExit", cancelOnExit).Warnln("Shutdown")
+	stopping = true
+	placer.block()
+
+	deadline := time.Now().Add(shutdownTimeout)
+	waitInflight(deadline)
+	drainEvents(eventChan)
+
+	// 下单请求都已完成，撤单后不会再有新挂单，等待订单关闭的推送
+	if cancelOnExit && !halted {
+		runAsync(func() {
+			if err := cancelAllOrders(); err != nil {
+				logrus.WithError(err).Errorln("ShutdownCancelAll")
+			}
+		})
+		for len(orderMap.Orders) > 0 && time.Now().Before(deadline) {
+			select {
+			case event := <-eventChan:
+				if _, ok := event.(*EventShutdown); !ok {
+					handleEvent(event)
+				}
+			case <-time.After(time.Millisecond * 200):
+			}
+		}
+		waitInflight(deadline)
+		drainEvents(eventChan)
+	}
+
+	persistGrids()
+	writeGridCurrent()
+	stopWebsocket()
+	log.WithField("orders", len(orderMap.Orders)).Infoln("ShutdownComplete")
+}
+
+// 等待进行中的交易所请求完成，最多等到 deadline 之后一秒
+func waitInflight(deadline time.Time) {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline) + time.Second):
+		log.Warnln("Shutdown: in-flight requests not finished")
+	}
+}
+
+// 处理已经在事件队列中的下单、撤单结果和订单推送
+func drainEvents(eventChan chan interface{}) {
+	for {
+		select {
+		case event := <-eventChan:
+			if _, ok := event.(*EventShutdown); !ok {
+				handleEvent(event)
+			}
+		default:
+			return
+		}
+	}
+}
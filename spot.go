@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 余额不足下单失败后暂停该方向下单的时间
+	balancePauseInterval = time.Minute
+	balancePausedUntil   = map[string]time.Time{}
+
+	// 现货计价币种的钱包余额，定时刷新，新买单金额不超过余额减去网格买单占用的部分
+	spotQuoteTotal      float64
+	spotQuoteKnown      bool
+	spotBalanceInterval = time.Second * 10
+	lastSpotBalanceTime time.Time
+	spotBalancePending  bool
+)
+
+// 现货计价币种余额的查询结果
+type EventSpotBalance struct {
+	Quote *Balance
+	Err   error
+}
+
+// 现货市场名称为 BASE/QUOTE，合约为 BTC-PERP 这样的形式
+func isSpotMarket(market string) bool {
+	return strings.Contains(market, "/")
+}
+
+func isSpot() bool {
+	return isSpotMarket(perpName)
+}
+
+// 现货的基础币种，网格持仓即该币种的钱包余额
+func spotBaseCurrency() string {
+	return strings.SplitN(perpName, "/", 2)[0]
+}
+
+// 现货的计价币种，买单占用该币种的余额
+func spotQuoteCurrency() string {
+	parts := strings.SplitN(perpName, "/", 2)
+	return parts[len(parts)-1]
+}
+
+// 现货使用 markets 接口获取盘口和交易单位
+func fetchSpotTicker() (*FuturesItem, error) {
+	market, err := client.getMarket(perpName)
+	if err != nil {
+		return nil, err
+	}
+	return &FuturesItem{
+		Ask:            market.Ask,
+		Bid:            market.Bid,
+		Name:           market.Name,
+		PriceIncrement: market.PriceIncrement,
+		SizeIncrement:  market.SizeIncrement,
+		Type:           market.Type,
+		Underlying:     market.Underlying,
+	}, nil
+}
+
+// 现货持仓为基础币种的钱包余额
+func spotPosition() (float64, error) {
+	balance, err := spotBalance()
+	if err != nil {
+		return 0, err
+	}
+	return balance.Total, nil
+}
+
+func spotBalance() (*Balance, error) {
+	return coinBalance(spotBaseCurrency())
+}
+
+func coinBalance(coin string) (*Balance, error) {
+	balances, err := client.getBalances()
+	if err != nil {
+		return nil, err
+	}
+	for _, balance := range balances {
+		if balance.Coin == coin {
+			return &balance, nil
+		}
+	}
+	return &Balance{Coin: coin}, nil
+}
+
+// 交易所返回的余额或保证金不足错误
+func isInsufficientBalance(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not enough balance") || strings.Contains(msg, "insufficient")
+}
+
+// 余额不足时暂停该方向下单一段时间，暂停开始时通知一次
+func onInsufficientBalance(req *PlaceRequest) {
+	paused := balancePaused(req.Side)
+	balancePausedUntil[req.Side] = time.Now().Add(balancePauseInterval)
+	log.WithField("side", req.Side).WithField("until", balancePausedUntil[req.Side]).Warnln("InsufficientBalance")
+	if !paused {
+		SendDingTalkAsync(fmt.Sprintln("余额不足，暂停下单:", perpName, req.Side, req.Price, req.Size,
+			"恢复时间：", balancePausedUntil[req.Side].Format("15:04:05")))
+	}
+}
+
+func balancePaused(side string) bool {
+	return time.Now().Before(balancePausedUntil[side])
+}
+
+// 定时在主循环外查询计价币种余额，模拟盘不检查
+func checkSpotBalance() {
+	if paperBook != nil || !isSpot() || spotBalancePending || time.Now().Sub(lastSpotBalanceTime) < spotBalanceInterval {
+		return
+	}
+	lastSpotBalanceTime = time.Now()
+	spotBalancePending = true
+	runAsync(func() {
+		balance, err := coinBalance(spotQuoteCurrency())
+		eventChan <- &EventSpotBalance{Quote: balance, Err: err}
+	})
+}
+
+func onSpotBalance(event *EventSpotBalance) {
+	spotBalancePending = false
+	if event.Err != nil {
+		logrus.WithError(event.Err).Errorln("GetSpotBalance")
+		return
+	}
+	spotQuoteTotal, spotQuoteKnown = event.Quote.Total, true
+}
+
+// 新买单可用的计价币种数量：余额减去网格买单未成交部分的金额，不是现货或余额未知时不限制
+func spotQuoteAvailable() float64 {
+	if !isSpot() || !spotQuoteKnown {
+		return math.Inf(1)
+	}
+	available := spotQuoteTotal
+	for _, grid := range grids {
+		for _, order := range grid.OpenOrders.Orders {
+			if order.Side == "buy" {
+				available -= (order.Qty - order.EQty) * grid.OpenAt
+			}
+		}
+	}
+	return available
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 跟踪网格参考价：空为不跟踪，ema 为盘口中间价的指数均线，vwap 为成交量加权均价
+	trailMode string
+	// 均线或成交量加权的时间窗口
+	trailWindow = time.Hour
+	// 检查是否需要平移的间隔
+	trailInterval = time.Minute * 5
+	// 参考价偏离网格中心超过该比例时平移
+	trailThreshold = 0.02
+	// 网格中心允许的范围，0 表示不限制
+	trailMin float64
+	trailMax float64
+
+	trailRef       float64
+	trailRefAt     time.Time
+	lastTrailTime  time.Time
+	trailPending   bool
+	priceIncrement float64
+)
+
+// 跟踪网格参考价的查询结果，目前只有成交量加权均价需要查询
+type EventTrail struct {
+	Ref float64
+	Err error
+}
+
+// 用最新盘口更新中间价均线
+func updateTrailEma(bid, ask float64) {
+	mid := (bid + ask) / 2
+	now := time.Now()
+	if trailRefAt.IsZero() {
+		trailRef = mid
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(trailRefAt))/float64(trailWindow))
+		trailRef += alpha * (mid - trailRef)
+	}
+	trailRefAt = now
+}
+
+// 定时检查参考价，偏离网格中心时平移网格
+func checkTrail() {
+	if trailMode == "" || trailPending || halted || stopping || time.Now().Sub(lastTrailTime) < trailInterval {
+		return
+	}
+	lastTrailTime = time.Now()
+
+	switch trailMode {
+	case "ema":
+		if !trailRefAt.IsZero() {
+			recenterGrids(trailRef)
+		}
+	case "vwap":
+		trailPending = true
+		window := trailWindow
+		runAsync(func() {
+			ref, err := fetchVwap(window)
+			eventChan <- &EventTrail{Ref: ref, Err: err}
+		})
+	}
+}
+
+func onTrail(event *EventTrail) {
+	trailPending = false
+	if event.Err != nil {
+		logrus.WithError(event.Err).Errorln("FetchVwap")
+		return
+	}
+	if halted || stopping {
+		return
+	}
+	trailRef, trailRefAt = event.Ref, time.Now()
+	recenterGrids(event.Ref)
+}
+
+// 使用分钟K线计算时间窗口内的成交量加权均价
+func fetchVwap(window time.Duration) (float64, error) {
+	end := time.Now()
+	candles, err := client.getCandles(perpName, 60, end.Add(-window), end)
+	if err != nil {
+		return 0, err
+	}
+
+	var amount, volume float64
+	for _, candle := range candles {
+		price := (candle.High + candle.Low + candle.Close) / 3
+		amount += price * candle.Volume
+		volume += candle.Volume
+	}
+	if volume <= 0 {
+		return 0, fmt.Errorf("no volume in last %v", window)
+	}
+	return amount / volume, nil
+}
+
+// 按整数个网格间距平移网格，重叠的档位保留运行状态，移出的档位退役只平仓
+func recenterGrids(ref float64) {
+	var active []*TradeGrid
+	for _, grid := range grids {
+		if !grid.Retired {
+			active = append(active, grid)
+		}
+	}
+	n := len(active)
+	if n < 2 {
+		return
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].OpenAt < active[j].OpenAt
+	})
+
+	// 做空网格的平仓价在开仓价下方，中心取全部价格的中点
+	low, high := active[0].OpenAt, active[n-1].OpenAt
+	for _, grid := range active {
+		low, high = math.Min(low, grid.CloseAt), math.Max(high, grid.CloseAt)
+	}
+	step := (active[n-1].OpenAt - active[0].OpenAt) / float64(n-1)
+	center := (low + high) / 2
+	if step <= 0 || math.Abs(ref-center) < center*trailThreshold {
+		return
+	}
+
+	shift := int(math.Round((ref - center) / step))
+	for shift > 0 && trailMax > 0 && center+float64(shift)*step > trailMax {
+		shift--
+	}
+	for shift < 0 && trailMin > 0 && center+float64(shift)*step < trailMin {
+		shift++
+	}
+	if shift == 0 {
+		log.WithField("ref", ref).WithField("center", center).Infoln("TrailAtBound")
+		return
+	}
+
+	// 新档位在原有范围内时沿用原档位价格和方向，超出范围时按端点网格外推
+	levelAt := func(i int) (gridLevel, *TradeGrid) {
+		if i >= 0 && i < n {
+			return levelOf(active[i]), active[i]
+		}
+		base, offset := active[0], float64(i)*step
+		if i >= n {
+			base, offset = active[n-1], float64(i-n+1)*step
+		}
+		return gridLevel{
+			OpenAt:  roundTo(base.OpenAt+offset, priceIncrement),
+			CloseAt: roundTo(base.CloseAt+offset, priceIncrement),
+		}, base
+	}
+
+	// 中性网格的多空分界随网格平移：分界及下方做多，上方做空，与 gen 生成时一致
+	neutral, boundary := neutralBoundary(active)
+	boundary += float64(shift) * step
+
+	ladder := make([]*TradeGrid, 0, n)
+	for i := 0; i < n; i++ {
+		level, base := levelAt(i + shift)
+		direction := base.Direction
+		if neutral {
+			direction = GridLong
+			if level.OpenAt > boundary+step/2 {
+				direction = GridShort
+			}
+			// 方向改变的档位按原价差在另一侧平仓
+			if (direction == GridShort) != base.short() {
+				spread := math.Abs(level.CloseAt - level.OpenAt)
+				level.CloseAt = roundTo(level.OpenAt+spread, priceIncrement)
+				if direction == GridShort {
+					level.CloseAt = roundTo(level.OpenAt-spread, priceIncrement)
+				}
+			}
+		}
+		ladder = append(ladder, &TradeGrid{
+			Uuid:        newGridUuid(),
+			OpenAt:      level.OpenAt,
+			CloseAt:     level.CloseAt,
+			OpenChance:  gridCapacity(base),
+			Direction:   direction,
+			OpenOrders:  NewOrderMap(),
+			CloseOrders: NewOrderMap(),
+		})
+	}
+
+	log.WithFields(logrus.Fields{
+		"mode":   trailMode,
+		"ref":    ref,
+		"center": center,
+		"shift":  shift,
+		"step":   step,
+	}).Infoln("TrailRecenter")
+
+	report := mergeGrids(ladder)
+	report.print()
+	persistGrids()
+	writeGridCurrent()
+
+	SendDingTalkAsync(fmt.Sprintln("网格平移:", perpName, "参考价：", ref, "平移档数：", shift,
+		"新区间：", ladder[0].OpenAt, "~", ladder[n-1].OpenAt, "退役：", len(report.Retired)))
+}
+
+// 同时有多空网格时为中性网格，返回最高的做多档位作为多空分界
+func neutralBoundary(active []*TradeGrid) (bool, float64) {
+	var hasLong, hasShort bool
+	var boundary float64
+	for _, grid := range active {
+		if grid.short() {
+			hasShort = true
+			continue
+		}
+		if !hasLong || grid.OpenAt > boundary {
+			boundary = grid.OpenAt
+		}
+		hasLong = true
+	}
+	return hasLong && hasShort, boundary
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"testing"
+)
+
+// 中性网格平移后，多空分界跟随平移：分界及下方做多，上方做空，改变方向的档位在另一侧平仓
+func TestRecenterNeutralGrids(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	saveFile = ""
+	orderAuditFile = ""
+	priceIncrement = 0.01
+	trailThreshold = 0.02
+	trailMin, trailMax = 0, 0
+	orderMap = NewOrderMap()
+
+	// 100~104 做多，105~109 做空
+	grids = []*TradeGrid{}
+	for i := 0; i < 10; i++ {
+		open := float64(100 + i)
+		grid := &TradeGrid{
+			Uuid:        newGridUuid(),
+			OpenAt:      open,
+			CloseAt:     open + 1,
+			OpenChance:  1,
+			Direction:   GridLong,
+			OpenOrders:  NewOrderMap(),
+			CloseOrders: NewOrderMap(),
+		}
+		if open > 104 {
+			grid.CloseAt, grid.Direction = open-1, GridShort
+		}
+		grids = append(grids, grid)
+	}
+
+	// 参考价上移 5 档，分界移到 109
+	recenterGrids(109.5)
+
+	var active []*TradeGrid
+	for _, grid := range grids {
+		if !grid.Retired {
+			active = append(active, grid)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].OpenAt < active[j].OpenAt
+	})
+	if len(active) != 10 || active[0].OpenAt != 105 {
+		t.Fatalf("unexpected ladder: %d grids from %v", len(active), active[0].OpenAt)
+	}
+	for _, grid := range active {
+		short := grid.OpenAt > 109
+		if grid.short() != short {
+			t.Errorf("grid %v direction %q, want short=%v", grid.OpenAt, grid.Direction, short)
+		}
+		if want := grid.OpenAt + grid.sign(); grid.CloseAt != want {
+			t.Errorf("grid %v closeAt %v, want %v", grid.OpenAt, grid.CloseAt, want)
+		}
+	}
+}
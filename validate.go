@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 配置问题，行列从 1 开始，0 表示不适用
+type ConfigProblem struct {
+	File    string
+	Row     int
+	Col     int
+	Message string
+	Warning bool
+}
+
+func (problem *ConfigProblem) String() string {
+	level := "ERROR"
+	if problem.Warning {
+		level = "WARN"
+	}
+	switch {
+	case problem.Row > 0 && problem.Col > 0:
+		return fmt.Sprintf("%s %s:%d:%d %s", level, problem.File, problem.Row, problem.Col, problem.Message)
+	case problem.Row > 0:
+		return fmt.Sprintf("%s %s:%d %s", level, problem.File, problem.Row, problem.Message)
+	}
+	return fmt.Sprintf("%s %s %s", level, problem.File, problem.Message)
+}
+
+type ConfigProblems []*ConfigProblem
+
+func (problems ConfigProblems) hasError() bool {
+	for _, problem := range problems {
+		if !problem.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (problems ConfigProblems) Error() string {
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 网格文件列定义
+var gridColumns = []string{"openPrice", "closePrice", "openChance", "closeChance"}
+
+// 解析网格文件内容，返回全部问题而不是遇到第一个错误就停止
+func parseGridRecords(file string, records [][]string) (string, string, []*TradeGrid, ConfigProblems) {
+	var problems ConfigProblems
+	addProblem := func(row, col int, warning bool, format string, args ...interface{}) {
+		problems = append(problems, &ConfigProblem{
+			File:    file,
+			Row:     row,
+			Col:     col,
+			Message: fmt.Sprintf(format, args...),
+			Warning: warning,
+		})
+	}
+
+	if len(records) == 0 {
+		addProblem(0, 0, false, "empty grid file")
+		return "", "", nil, problems
+	}
+
+	var perp, future string
+	if len(records[0]) < 2 {
+		addProblem(1, 0, false, "header needs perp and future name, got %d column(s)", len(records[0]))
+	} else if perp, future = records[0][0], records[0][1]; perp == "" {
+		addProblem(1, 1, false, "perp name is empty")
+	}
+
+	var fileGrids []*TradeGrid
+	levels := map[gridLevel]int{}
+	for index := 2; index < len(records); index++ {
+		record := records[index]
+		row := index + 1
+		if len(record) < len(gridColumns) {
+			addProblem(row, 0, false, "need %d columns (%s), got %d",
+				len(gridColumns), strings.Join(gridColumns, ","), len(record))
+			continue
+		}
+
+		var values [4]float64
+		valid := true
+		for col := range gridColumns {
+			value, err := strconv.ParseFloat(strings.TrimSpace(record[col]), 64)
+			if err != nil {
+				addProblem(row, col+1, false, "invalid %s %q", gridColumns[col], record[col])
+				valid = false
+				continue
+			}
+			values[col] = value
+		}
+		if !valid {
+			continue
+		}
+
+		grid := &TradeGrid{
+			Uuid:        newGridUuid(),
+			OpenAt:      values[0],
+			CloseAt:     values[1],
+			OpenChance:  values[2],
+			CloseChance: values[3],
+			OpenOrders:  NewOrderMap(),
+			CloseOrders: NewOrderMap(),
+			Row:         row,
+		}
+		// 可选的第 9 列指定网格 Uuid，用于与 save.yaml 中的网格匹配
+		if len(record) > 8 && record[8] != "" {
+			grid.Uuid = record[8]
+		}
+		// 可选的第 10 列指定网格方向，默认做多
+		if len(record) > 9 {
+			switch direction := strings.TrimSpace(record[9]); direction {
+			case "", GridLong:
+			case GridShort:
+				grid.Direction = GridShort
+			default:
+				addProblem(row, 10, false, "direction must be long or short, got %q", direction)
+			}
+		}
+
+		if grid.short() && isSpotMarket(perp) {
+			addProblem(row, 10, false, "short grid is not supported on spot market %s", perp)
+		}
+		if grid.OpenAt <= 0 {
+			addProblem(row, 1, false, "openPrice must be positive")
+		}
+		if grid.short() && grid.CloseAt >= grid.OpenAt {
+			addProblem(row, 2, false, "closePrice %v of short grid must be less than openPrice %v", grid.CloseAt, grid.OpenAt)
+		} else if !grid.short() && grid.CloseAt <= grid.OpenAt {
+			addProblem(row, 2, false, "closePrice %v must be greater than openPrice %v", grid.CloseAt, grid.OpenAt)
+		}
+		if grid.CloseAt <= 0 {
+			addProblem(row, 2, false, "closePrice must be positive")
+		}
+		if grid.OpenChance < 0 {
+			addProblem(row, 3, false, "openChance must not be negative")
+		}
+		if grid.CloseChance < 0 {
+			addProblem(row, 4, false, "closeChance must not be negative")
+		}
+
+		if prev, found := levels[levelOf(grid)]; found {
+			addProblem(row, 0, false, "duplicated level %v-%v, first defined at row %d", grid.OpenAt, grid.CloseAt, prev)
+		} else if prev := overlappedGrid(fileGrids, grid); prev != nil {
+			addProblem(row, 1, true, "range %v-%v overlaps row %d (%v-%v)", grid.OpenAt, grid.CloseAt, prev.Row, prev.OpenAt, prev.CloseAt)
+		}
+		levels[levelOf(grid)] = row
+
+		fileGrids = append(fileGrids, grid)
+	}
+
+	return perp, future, fileGrids, problems
+}
+
+// 开仓价到平仓价的区间与之前的网格重叠时返回该网格，端点相接不算重叠
+func overlappedGrid(fileGrids []*TradeGrid, grid *TradeGrid) *TradeGrid {
+	low, high := math.Min(grid.OpenAt, grid.CloseAt), math.Max(grid.OpenAt, grid.CloseAt)
+	for _, prev := range fileGrids {
+		if low < math.Max(prev.OpenAt, prev.CloseAt) && high > math.Min(prev.OpenAt, prev.CloseAt) {
+			return prev
+		}
+	}
+	return nil
+}
+
+func isAligned(value, increment float64) bool {
+	if increment <= 0 {
+		return true
+	}
+	steps := value / increment
+	return math.Abs(steps-math.Round(steps)) < 1e-6
+}
+
+// 按最小变动单位取最接近的整数倍，并去掉浮点误差
+func roundTo(value, increment float64) float64 {
+	if increment <= 0 {
+		return value
+	}
+	return trimFloat(math.Round(value/increment)*increment, increment)
+}
+
+// 按最小变动单位向上取整数倍
+func ceilTo(value, increment float64) float64 {
+	if increment <= 0 {
+		return value
+	}
+	return trimFloat(math.Ceil(value/increment-1e-9)*increment, increment)
+}
+
+// 按最小变动单位向下取整数倍
+func floorTo(value, increment float64) float64 {
+	if increment <= 0 {
+		return value
+	}
+	return trimFloat(math.Floor(value/increment+1e-9)*increment, increment)
+}
+
+// 按最小变动单位的小数位数格式化，去掉乘法产生的浮点误差
+func trimFloat(value, increment float64) float64 {
+	decimals := 0
+	if s := strconv.FormatFloat(increment, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+	trimmed, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', decimals, 64), 64)
+	return trimmed
+}
+
+// 检查网格价格和数量是否符合市场的最小变动单位，下单时会取整，只有取整后不足最小数量才是错误
+func validateGridIncrements(file string, fileGrids []*TradeGrid, meta *MarketMeta) ConfigProblems {
+	var problems ConfigProblems
+	for _, grid := range fileGrids {
+		row := grid.Row
+		check := func(col int, name string, value, increment float64) {
+			if !isAligned(value, increment) {
+				problems = append(problems, &ConfigProblem{
+					File:    file,
+					Row:     row,
+					Col:     col,
+					Message: fmt.Sprintf("%s %v is not a multiple of %v, orders will be rounded", name, value, increment),
+					Warning: true,
+				})
+			}
+		}
+		check(1, "openPrice", grid.OpenAt, meta.PriceIncrement)
+		check(2, "closePrice", grid.CloseAt, meta.PriceIncrement)
+		check(3, "openChance", grid.OpenChance, meta.SizeIncrement)
+		check(4, "closeChance", grid.CloseChance, meta.SizeIncrement)
+
+		// 开仓和平仓分别下单，每一边都要满足最小下单数量
+		checkSize := func(col int, name string, size float64) {
+			if size > 0 && floorTo(size, meta.SizeIncrement) < meta.MinSize {
+				problems = append(problems, &ConfigProblem{
+					File:    file,
+					Row:     row,
+					Col:     col,
+					Message: fmt.Sprintf("%s %v is below min size %v", name, size, meta.MinSize),
+				})
+			}
+		}
+		checkSize(3, "openChance", grid.OpenChance)
+		checkSize(4, "closeChance", grid.CloseChance)
+	}
+	return problems
+}
+
+// 校验基本配置，默认值已经在解析前填充
+func (config *Config) validate(file string) ConfigProblems {
+	var problems ConfigProblems
+	addProblem := func(warning bool, format string, args ...interface{}) {
+		problems = append(problems, &ConfigProblem{
+			File:    file,
+			Message: fmt.Sprintf(format, args...),
+			Warning: warning,
+		})
+	}
+
+	if config.Keystore != "" {
+		if _, err := os.Stat(config.Keystore); err != nil {
+			addProblem(false, "keystore: %v", err)
+		}
+		if config.ApiKey != "" || config.SecretKey != "" {
+			addProblem(true, "apiKey/secretKey are ignored when keystore is set")
+		}
+	} else {
+		if config.ApiKey == "" {
+			addProblem(false, "apiKey is empty")
+		}
+		if config.SecretKey == "" {
+			addProblem(false, "secretKey is empty")
+		}
+	}
+	if config.Ding == "" {
+		addProblem(true, "ding is empty, notifications are disabled")
+	}
+	intervals := []struct {
+		name  string
+		value int
+	}{
+		{"checkInterval", config.CheckInterval},
+		{"quickRecheckInterval", config.QuickRecheckInterval},
+		{"cancelRetryInterval", config.CancelRetryInterval},
+		{"cancelAlertTimeout", config.CancelAlertTimeout},
+		{"positionReportWindow", config.PositionReportWindow},
+		{"shutdownTimeout", config.ShutdownTimeout},
+		{"placeWorkers", config.PlaceWorkers},
+		{"placeQueueDepth", config.PlaceQueueDepth},
+		{"reconcileInterval", config.ReconcileInterval},
+		{"trailWindow", config.TrailWindow},
+		{"trailInterval", config.TrailInterval},
+		{"volWindow", config.VolWindow},
+		{"marketMetaInterval", config.MarketMetaInterval},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			addProblem(false, "%s must be positive, got %d", interval.name, interval.value)
+		}
+	}
+	switch config.MinSpreadAction {
+	case "warn", "refuse":
+	default:
+		addProblem(false, "minSpreadAction must be warn or refuse, got %q", config.MinSpreadAction)
+	}
+	switch config.ReconcileAction {
+	case "alert", "correct":
+	default:
+		addProblem(false, "reconcileAction must be alert or correct, got %q", config.ReconcileAction)
+	}
+	switch config.TrailMode {
+	case "", "ema", "vwap":
+	default:
+		addProblem(false, "trailMode must be ema or vwap, got %q", config.TrailMode)
+	}
+	if config.TrailThreshold < 0 {
+		addProblem(false, "trailThreshold must not be negative")
+	}
+	if config.TrailMax > 0 && config.TrailMin > config.TrailMax {
+		addProblem(false, "trailMin %v is greater than trailMax %v", config.TrailMin, config.TrailMax)
+	}
+	switch config.VolMode {
+	case "", "stdev", "atr":
+	default:
+		addProblem(false, "volMode must be stdev or atr, got %q", config.VolMode)
+	}
+	if config.VolMultiplier <= 0 {
+		addProblem(false, "volMultiplier must be positive")
+	}
+	if config.VolSpreadMin <= 0 || config.VolSpreadMax < config.VolSpreadMin {
+		addProblem(false, "volSpreadMin/volSpreadMax must satisfy 0 < min <= max, got %v/%v", config.VolSpreadMin, config.VolSpreadMax)
+	}
+	switch config.ForeignAction {
+	case "ignore", "pause", "adjust":
+	default:
+		addProblem(false, "foreignAction must be ignore, pause or adjust, got %q", config.ForeignAction)
+	}
+	if config.ClientIdPrefix == "" {
+		addProblem(true, "clientIdPrefix is empty, manual orders can not be detected")
+	}
+	if config.DriftTolerance < 0 {
+		addProblem(false, "driftTolerance must not be negative")
+	}
+	switch config.Report {
+	case "", "daily", "hourly":
+	default:
+		addProblem(false, "report must be daily or hourly, got %q", config.Report)
+	}
+	if config.FundingPauseRate < 0 {
+		addProblem(false, "fundingPauseRate must not be negative")
+	}
+	if config.ConfirmPosition < 0 {
+		addProblem(false, "confirmPosition must not be negative")
+	}
+	if config.FlattenSlice < 0 {
+		addProblem(false, "flattenSlice must not be negative")
+	}
+	if config.HttpAddr != "" && config.HttpToken == "" {
+		addProblem(true, "httpAddr is set without httpToken, anyone reaching it can halt trading")
+	}
+	return problems
+}
+
+// json 解析错误转换为行列位置
+func jsonProblem(file string, content []byte, err error) *ConfigProblem {
+	problem := &ConfigProblem{File: file, Message: err.Error()}
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return problem
+	}
+	before := content[:offset]
+	problem.Row = bytes.Count(before, []byte("\n")) + 1
+	problem.Col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return problem
+}
+
+// validate 子命令：校验配置和网格文件，不会下单
+func runValidate(cfg string, gridFile string) bool {
+	var problems ConfigProblems
+
+	if cfg != "" {
+		content, err := ioutil.ReadFile(cfg)
+		if err != nil {
+			problems = append(problems, &ConfigProblem{File: cfg, Message: err.Error()})
+		} else {
+			config, configProblems := parseConfig(cfg, content)
+			problems = append(problems, configProblems...)
+			if config != nil && client == nil {
+				client = newFtxClient(config.ApiKey, config.SecretKey, config.SubAccount)
+			}
+		}
+	}
+
+	if gridFile != "" {
+		records, err := readCsv(gridFile)
+		if err != nil {
+			problems = append(problems, &ConfigProblem{File: gridFile, Message: err.Error()})
+		} else {
+			perp, _, fileGrids, gridProblems := parseGridRecords(gridFile, records)
+			problems = append(problems, gridProblems...)
+
+			if perp != "" && client != nil {
+				perpName = perp
+				meta, err := fetchMarketMeta()
+				if err != nil {
+					problems = append(problems, &ConfigProblem{
+						File:    gridFile,
+						Message: fmt.Sprintf("skip increment check, fetch %s: %v", perp, err),
+						Warning: true,
+					})
+				} else {
+					problems = append(problems, validateGridIncrements(gridFile, fileGrids, meta)...)
+				}
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, problem.String())
+	}
+	if !problems.hasError() {
+		fmt.Fprintln(os.Stdout, "OK")
+	}
+	return !problems.hasError()
+}
+
+func readCsv(file string) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	// 每行列数允许不同，由校验给出具体问题
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 波动率计算方式：空为不调整，stdev 为收益率标准差，atr 为分钟真实波幅均值
+	volMode string
+	// 计算波动率的盘口历史窗口
+	volWindow = time.Hour
+	// 平仓价差 = 波动率 * volMultiplier，限制在 volSpreadMin~volSpreadMax 比例之间
+	volMultiplier = 1.0
+	volSpreadMin  = 0.002
+	volSpreadMax  = 0.05
+
+	volSamples []volSample
+	// 最近一次使用的价差比例，变化时打印日志
+	lastVolSpread float64
+)
+
+type volSample struct {
+	At  time.Time
+	Mid float64
+}
+
+// 记录盘口中间价，只保留窗口内的样本
+func recordVolSample(bid, ask float64) {
+	now := time.Now()
+	volSamples = append(volSamples, volSample{At: now, Mid: (bid + ask) / 2})
+
+	drop := 0
+	for drop < len(volSamples) && now.Sub(volSamples[drop].At) > volWindow {
+		drop++
+	}
+	volSamples = volSamples[drop:]
+}
+
+// 窗口内的已实现波动率，以价格比例表示，样本不足半个窗口时返回 false
+func realizedVol() (float64, bool) {
+	if len(volSamples) < 20 || volSamples[len(volSamples)-1].At.Sub(volSamples[0].At) < volWindow/2 {
+		return 0, false
+	}
+
+	switch volMode {
+	case "stdev":
+		var sum float64
+		for i := 1; i < len(volSamples); i++ {
+			r := math.Log(volSamples[i].Mid / volSamples[i-1].Mid)
+			sum += r * r
+		}
+		return math.Sqrt(sum), true
+	case "atr":
+		// 按分钟聚合成K线，真实波幅取高低价差和与上一根收盘价的跳空中较大者
+		var ranges []float64
+		var high, low, close, prevClose float64
+		var minute time.Time
+		for _, sample := range volSamples {
+			at := sample.At.Truncate(time.Minute)
+			if at != minute {
+				if !minute.IsZero() {
+					ranges = append(ranges, trueRange(high, low, prevClose))
+					prevClose = close
+				}
+				minute, high, low = at, sample.Mid, sample.Mid
+			}
+			high, low, close = math.Max(high, sample.Mid), math.Min(low, sample.Mid), sample.Mid
+		}
+		ranges = append(ranges, trueRange(high, low, prevClose))
+
+		var sum float64
+		for _, r := range ranges {
+			sum += r
+		}
+		return sum / float64(len(ranges)) / close, true
+	}
+	return 0, false
+}
+
+func trueRange(high, low, prevClose float64) float64 {
+	if prevClose == 0 {
+		return high - low
+	}
+	return math.Max(high-low, math.Max(math.Abs(high-prevClose), math.Abs(low-prevClose)))
+}
+
+// 按当前波动率调整没有持仓的网格的平仓价，做多网格上移，做空网格下移，已有持仓或平仓挂单的网格保持不变
+func adaptSpacing() {
+	spread, ok := 0.0, false
+	if volMode != "" {
+		var vol float64
+		if vol, ok = realizedVol(); ok {
+			spread = math.Min(math.Max(vol*volMultiplier, volSpreadMin), volSpreadMax)
+		}
+	}
+
+	adjusted := 0
+	for _, grid := range grids {
+		if grid.Retired || grid.CloseChance > 0 || len(grid.CloseOrders.Orders) > 0 {
+			continue
+		}
+
+		exitAt := 0.0
+		if ok {
+			// 做空网格的平仓价在开仓价下方，取整方向都使价差变大
+			if grid.short() {
+				exitAt = floorTo(grid.OpenAt*(1-spread), priceIncrement)
+			} else {
+				exitAt = ceilTo(grid.OpenAt*(1+spread), priceIncrement)
+			}
+			// 价差不能覆盖手续费时使用网格文件的平仓价
+			if grid.sign()*(exitAt-grid.OpenAt)-makerFee*(grid.OpenAt+exitAt) <= 0 {
+				exitAt = 0
+			}
+		} else if volMode != "" {
+			continue
+		}
+
+		if grid.ExitAt != exitAt {
+			grid.ExitAt = exitAt
+			adjusted++
+		}
+	}
+
+	if (ok && math.Abs(spread-lastVolSpread) > lastVolSpread*0.1) || (!ok && adjusted > 0) {
+		lastVolSpread = spread
+		log.WithFields(logrus.Fields{
+			"mode":     volMode,
+			"spread":   spread,
+			"adjusted": adjusted,
+		}).Infoln("AdaptSpacing")
+	}
+}
+
+// 网格当前使用的平仓价
+func (grid *TradeGrid) closePrice() float64 {
+	// 与开仓价方向不符的调整价是过期的，不能低于开仓价卖出或高于开仓价买回
+	if grid.ExitAt > 0 && grid.sign()*(grid.ExitAt-grid.OpenAt) > 0 {
+		return grid.ExitAt
+	}
+	return grid.CloseAt
+}
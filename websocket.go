@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -67,11 +68,35 @@ func (client *WebsocketClient) close() {
 	client.conn.Close()
 }
 
+// 发送关闭帧后关闭连接
+func (client *WebsocketClient) shutdown() {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	client.conn.Close()
+}
+
+type loginArgs struct {
+	Key        string `json:"key"`
+	Sign       string `json:"sign"`
+	Time       int64  `json:"time"`
+	Subaccount string `json:"subaccount"`
+}
+
+type loginRequest struct {
+	Op   string    `json:"op"`
+	Args loginArgs `json:"args"`
+}
+
 func (client *WebsocketClient) login() error {
 	ts := time.Now().UnixNano() / int64(time.Millisecond)
 	signature := sign(fmt.Sprintf("%dwebsocket_login", ts), client.secret)
-	body := fmt.Sprintf(`{"op": "login", "args": {"key": "%s", "sign": "%s", "time": %d, "subaccount":"%s"}}`, client.apiKey, signature, ts, client.subAccount)
-	if err := client.send(websocket.TextMessage, []byte(body)); err != nil {
+	request := loginRequest{Op: "login", Args: loginArgs{Key: client.apiKey, Sign: signature, Time: ts, Subaccount: client.subAccount}}
+	body, _ := json.Marshal(request)
+
+	// 日志中隐藏 key 和签名
+	request.Args.Key, request.Args.Sign = "***", "***"
+	logged, _ := json.Marshal(request)
+	if err := client.sendLogged(websocket.TextMessage, body, logged); err != nil {
 		client.authed = -1
 		return err
 	}
@@ -79,7 +104,12 @@ func (client *WebsocketClient) login() error {
 }
 
 func (client *WebsocketClient) send(t int, body []byte) error {
-	logrus.Println("send", string(body))
+	return client.sendLogged(t, body, body)
+}
+
+// logged 为写入日志的内容，包含密钥的请求需要在格式化前替换
+func (client *WebsocketClient) sendLogged(t int, body, logged []byte) error {
+	logrus.Println("send", string(logged))
 	client.conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
 	if err := client.conn.WriteMessage(t, body); err != nil {
 		logrus.WithError(err).Errorln("WebsocketWriteMessageFailed")